@@ -1,6 +1,8 @@
 package gobalena
 
 import (
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -14,34 +16,125 @@ const (
 
 type SturdyClient struct {
 	*resty.Client
+
+	retryPolicy RetryPolicy
+	limiter     *RateLimiter
+	onThrottle  func(ThrottleEvent)
+	logger      *slog.Logger
+	telemetry   *telemetry
+	redactor    *Redactor
 }
 
 func NewSturdyHTTPClient() *SturdyClient {
-	return &SturdyClient{
-		resty.New().
-			SetHeader("Content-Type", "application/json").
-			SetRetryCount(APIRetryCount).
-			SetRetryWaitTime(APIRetryBackoff).
-			// SetDebug(true).
-			// EnableGenerateCurlOnDebug().
-			// EnableTrace().
-			SetRetryMaxWaitTime(MaxAPIRetryBackoff).
-			AddRetryCondition(func(r *resty.Response, err error) bool {
-				if err != nil {
-					// If there's an error at the network level, always retry
-					return true
-				}
-
-				statusCode := r.StatusCode()
-				// Retry for 5XX server errors or 429 Too Many Requests
-				if statusCode == 429 || (statusCode >= 500 && statusCode <= 599) {
-					return true
-				}
-
-				// Do not retry on 4XX errors other than 429
-				return false
-			}),
+	return newSturdyClient(resty.New())
+}
+
+func newSturdyClient(client *resty.Client) *SturdyClient {
+	c := &SturdyClient{logger: nopLogger}
+	c.Client = client.
+		SetHeader("Content-Type", "application/json").
+		SetRetryCount(APIRetryCount).
+		SetRetryWaitTime(APIRetryBackoff).
+		// SetDebug(true).
+		// EnableGenerateCurlOnDebug().
+		// EnableTrace().
+		SetRetryMaxWaitTime(MaxAPIRetryBackoff).
+		SetRetryAfter(c.retryAfter).
+		OnBeforeRequest(c.attachRedactor).
+		OnBeforeRequest(c.startAttempt).
+		OnBeforeRequest(c.throttle).
+		AddRetryCondition(c.shouldRetry).
+		AddRetryHook(c.logRetry).
+		AddRetryHook(c.traceRetry).
+		OnResponseLog(c.observeResponseLog).
+		OnAfterResponse(c.observeResponse).
+		OnAfterResponse(c.logResponse).
+		OnAfterResponse(c.traceResponse).
+		OnError(c.logError).
+		OnError(c.traceError)
+
+	return c
+}
+
+// SetRateLimiter makes every request wait for a token of limiter first. Pass
+// the same limiter to several clients to share the budget between them.
+func (c *SturdyClient) SetRateLimiter(limiter *RateLimiter) *SturdyClient {
+	c.limiter = limiter
+	return c
+}
+
+// OnThrottle registers hook to be called whenever a request is held back by
+// the rate limiter or by a Retry-After header.
+func (c *SturdyClient) OnThrottle(hook func(ThrottleEvent)) *SturdyClient {
+	c.onThrottle = hook
+	return c
+}
+
+func (c *SturdyClient) throttle(_ *resty.Client, r *resty.Request) error {
+	if c.limiter == nil {
+		return nil
+	}
+
+	wait, err := c.limiter.Wait(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// Waits below a millisecond are just the limiter's bookkeeping.
+	if wait >= time.Millisecond {
+		c.reportThrottle(ThrottleRateLimiter, r, wait)
 	}
+
+	return nil
+}
+
+// retryAfter honours the Retry-After header of 429 and 503 responses in full,
+// for the retry as well as for the shared rate limiter; bound it with the
+// request's context. Returning 0 falls back to the regular backoff.
+func (c *SturdyClient) retryAfter(client *resty.Client, response *resty.Response) (time.Duration, error) {
+	if response.StatusCode() != http.StatusTooManyRequests && response.StatusCode() != http.StatusServiceUnavailable {
+		return 0, nil
+	}
+
+	wait := parseRetryAfter(response.Header())
+	if wait == 0 {
+		return 0, nil
+	}
+
+	if c.limiter != nil {
+		c.limiter.Pause(wait)
+	}
+	c.reportThrottle(ThrottleRetryAfter, response.Request, wait)
+
+	// resty caps the wait returned here at RetryMaxWaitTime, so sleep off
+	// the rest of a longer one first.
+	if client.RetryMaxWaitTime > 0 && wait > client.RetryMaxWaitTime {
+		timer := time.NewTimer(wait - client.RetryMaxWaitTime)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-response.Request.Context().Done():
+			return 0, response.Request.Context().Err()
+		}
+
+		wait = client.RetryMaxWaitTime
+	}
+
+	return wait, nil
+}
+
+func (c *SturdyClient) reportThrottle(reason ThrottleReason, r *resty.Request, wait time.Duration) {
+	if c.onThrottle == nil {
+		return
+	}
+
+	c.onThrottle(ThrottleEvent{
+		Reason: reason,
+		Method: r.Method,
+		Path:   requestPath(r),
+		Wait:   wait,
+	})
 }
 
 func (c *SturdyClient) SetRetryCount(count int) *SturdyClient {
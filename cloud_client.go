@@ -3,12 +3,20 @@ package gobalena
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"log/slog"
 	"mime"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/metric"
 )
 
 const (
@@ -17,7 +25,12 @@ const (
 	DeviceTypeGeneric     DeviceType = "genericx86-64-ext"
 	DeviceTypeSurfaceGo   DeviceType = "surface-go"
 	DeviceTypeSurfacePro6 DeviceType = "surface-pro-6"
+)
 
+// Pre-built query strings, kept for callers that assemble their own URLs.
+//
+// Deprecated: build queries with NewQuery instead.
+const (
 	DeviceQuerySelector = "$select=id,uuid,ip_address,mac_address,public_address,device_name,os_version,os_variant,supervisor_version,is_online,last_connectivity_event,is_web_accessible,latitude,longitude,location,created_at,overall_status"
 	// See <https://docs.balena.io/reference/api/resources/device/>.
 	DeviceDetailsQuerySelector    = "$expand=is_running__release($expand=is_created_by__user($select=id,username,created_at),release_tag($select=tag_key,value,id)),should_be_running__release($expand=is_created_by__user($select=id,username,created_at),release_tag($select=tag_key,value,id)),belongs_to__application($select=id,app_name),is_of__device_type($select=slug,name)"
@@ -25,11 +38,70 @@ const (
 	OrderByCreatedAtQuerySelector = "$orderby=created_at%20desc"
 )
 
+var deviceFields = []string{
+	"id", "uuid", "ip_address", "mac_address", "public_address", "device_name",
+	"os_version", "os_variant", "supervisor_version", "is_online",
+	"last_connectivity_event", "is_web_accessible", "latitude", "longitude",
+	"location", "created_at", "overall_status",
+}
+
+func deviceQuery() *Query {
+	return NewQuery().Select(deviceFields...)
+}
+
+func releaseDetailsQuery() *Query {
+	return NewQuery().
+		Expand("is_created_by__user", NewQuery().Select("id", "username", "created_at")).
+		Expand("release_tag", NewQuery().Select("tag_key", "value", "id"))
+}
+
+// See <https://docs.balena.io/reference/api/resources/device/>.
+func deviceDetailsQuery() *Query {
+	return deviceQuery().
+		Expand("is_running__release", releaseDetailsQuery()).
+		Expand("should_be_running__release", releaseDetailsQuery()).
+		Expand("belongs_to__application", NewQuery().Select("id", "app_name")).
+		Expand("is_of__device_type", NewQuery().Select("slug", "name"))
+}
+
+func fleetReleasesQuery(fleetID int) *Query {
+	return NewQuery().
+		Filter(Eq("belongs_to__application", fleetID)).
+		OrderBy("created_at", Desc).
+		Expand("is_created_by__user", NewQuery().Select("id", "username", "created_at")).
+		ExpandCount("is_running_on__device", nil).
+		Expand("release_tag", NewQuery().Select("tag_key", "value", "id"))
+}
+
+func serviceEnvVarsQuery(fleetID int) *Query {
+	return NewQuery().
+		Filter(Any("service", "s", Eq("s/application", fleetID))).
+		Select("id", "name", "value").
+		Expand("service", NewQuery().Select("id", "service_name"))
+}
+
+func deviceServiceInstallFilter(balenaDeviceID int) Filter {
+	return Any("service_install", "si", Eq("si/device", balenaDeviceID))
+}
+
+func deviceServiceEnvVarsQuery(balenaDeviceID int) *Query {
+	return NewQuery().
+		Filter(deviceServiceInstallFilter(balenaDeviceID)).
+		Select("id", "name", "value").
+		Expand("service_install", NewQuery().
+			Select("id").
+			Expand("installs__service", NewQuery().Select("id", "service_name")))
+}
+
 type CloudClient interface {
 	GetDevice(ctx context.Context, balenaDeviceUUID string) (*Device, error)
 	GetDeviceDetails(ctx context.Context, balenaDeviceUUID string) (*Device, error)
 	GetDevicesDetails(ctx context.Context, balenaDeviceUUIDs []string) ([]Device, error)
+	IterDevicesDetails(ctx context.Context, balenaDeviceUUIDs []string) iter.Seq2[Device, error]
+	ListFleetDevices(ctx context.Context, fleetName string, opts ListDevicesOptions) ([]Device, error)
+	FindDevicesByNetwork(ctx context.Context, fleetName, query string) ([]Device, error)
 	GetDeviceID(ctx context.Context, balenaDeviceUUID string) (int, error)
+	ResolveDevice(ctx context.Context, ref string) (string, error)
 	GetFleet(ctx context.Context, name string) (*Fleet, error)
 	RegisterDevice(ctx context.Context, balenaDeviceUUID, fleetName string, deviceType DeviceType) error
 	DeleteDevice(ctx context.Context, balenaDeviceUUID string) error
@@ -37,57 +109,187 @@ type CloudClient interface {
 
 	CreateDeviceEnvVar(ctx context.Context, balenaDeviceUUID, key string, value string) error
 	GetDeviceEnvVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceEnvVar, error)
+	IterDeviceEnvVars(ctx context.Context, balenaDeviceUUID string) iter.Seq2[DeviceEnvVar, error]
 	GetDeviceEnvVarID(ctx context.Context, balenaDeviceID int, key string) (int, error)
 	UpdateDeviceEnvVar(ctx context.Context, balenaDeviceID, envVarID int, value string) error
 	DeleteDeviceEnvVar(ctx context.Context, balenaDeviceID, envVarID int) error
+	SetDeviceEnvVar(ctx context.Context, balenaDeviceUUID, name, value string) (bool, error)
 
 	GetFleetEnvVars(ctx context.Context, name string) ([]FleetEnvVar, error)
+	IterFleetEnvVars(ctx context.Context, name string) iter.Seq2[FleetEnvVar, error]
+	CreateFleetEnvVar(ctx context.Context, fleetName, name, value string) error
+	UpdateFleetEnvVar(ctx context.Context, fleetName, name, value string) error
+	UpsertFleetEnvVar(ctx context.Context, fleetName, name, value string) (bool, error)
+	DeleteFleetEnvVar(ctx context.Context, fleetName, name string) error
 	GetServiceEnvVars(ctx context.Context, fleetName string) ([]ServiceEnvVar, error)
+	CreateServiceEnvVar(ctx context.Context, fleetName, serviceName, name, value string) error
+	UpdateServiceEnvVar(ctx context.Context, fleetName, serviceName, name, value string) error
+	UpsertServiceEnvVar(ctx context.Context, fleetName, serviceName, name, value string) (bool, error)
+	DeleteServiceEnvVar(ctx context.Context, fleetName, serviceName, name string) error
 	GetDeviceServiceInstallIDs(ctx context.Context, balenaDeviceUUID string) ([]DeviceServiceInstall, error)
+	GetEffectiveEnvVars(ctx context.Context, balenaDeviceUUID string) (map[string][]GenericEnvVar, error)
+
+	GetDeviceConfigVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceConfigVar, error)
+	GetDeviceConfigVar(ctx context.Context, balenaDeviceUUID, name string) (*DeviceConfigVar, error)
+	SetDeviceConfigVar(ctx context.Context, balenaDeviceUUID, name, value string) (bool, error)
+	DeleteDeviceConfigVar(ctx context.Context, balenaDeviceUUID, name string) error
+	GetFleetConfigVars(ctx context.Context, fleetName string) ([]FleetConfigVar, error)
+	GetFleetConfigVar(ctx context.Context, fleetName, name string) (*FleetConfigVar, error)
+	SetFleetConfigVar(ctx context.Context, fleetName, name, value string) (bool, error)
+	DeleteFleetConfigVar(ctx context.Context, fleetName, name string) error
 
 	CreateDeviceServiceEnvVar(ctx context.Context, balenaDeviceUUID, name string, serviceInstallID int, value string) error
 	GetDeviceServiceEnvVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceServiceEnvVar, error)
 	UpdateDeviceServiceEnvVar(ctx context.Context, balenaDeviceID, envVarID int, value string) error
+	SetDeviceServiceEnvVar(ctx context.Context, balenaDeviceUUID, serviceName, name, value string) (bool, error)
 	ForceApply(ctx context.Context, balenaDeviceUUID string) error
 	RestartAllServices(ctx context.Context, balenaDeviceUUID string, force bool) error
 
 	DeleteDeviceServiceEnvVar(ctx context.Context, balenaDeviceID, envVarID int) error
 
 	SetDeviceName(ctx context.Context, balenaDeviceUUID, name string) error
+	GetDeviceTags(ctx context.Context, balenaDeviceUUID string) ([]DeviceTag, error)
+	SetDeviceTag(ctx context.Context, balenaDeviceUUID, key, value string) error
+	DeleteDeviceTag(ctx context.Context, balenaDeviceUUID, key string) error
+	ListDevicesByTag(ctx context.Context, fleetName, key, value string) ([]Device, error)
 	DownloadOS(ctx context.Context, writer io.Writer, fleet string, deviceType DeviceType, version string, headerSetter HeaderSetter) (string, error)
 	MoveDeviceToFleet(ctx context.Context, balenaDeviceUUID, fleetName string) error
 	EnablePublicDeviceURL(ctx context.Context, balenaDeviceUUID string) error
 	HostLogin(token string) error
 	GetFleetReleases(ctx context.Context, name string) ([]Release, error)
+	IterFleetReleases(ctx context.Context, name string) iter.Seq2[Release, error]
 	PinDeviceToRelease(ctx context.Context, balenaDeviceUUID string, releaseID int) error
 }
 
+// valueRequest is the body of every PATCH changing the value of a variable
+// or tag.
+type valueRequest struct {
+	Value string `json:"value"`
+}
+
 type cloudClient struct {
-	httpClient *SturdyClient
+	httpClient      *SturdyClient
+	pageSize        int
+	logger          *slog.Logger
+	resolver        *ResolverCache
+	resolverLookups metric.Int64Counter
+}
+
+// observeEnvVar teaches the redactor the value of a sensitive variable
+// before it is sent.
+func (b *cloudClient) observeEnvVar(name, value string) {
+	b.httpClient.Redactor().ObserveEnvVar(name, value)
+}
+
+// notFound reports whether err is a lookup finding nothing.
+func notFound(err error) bool {
+	return errors.Is(err, ErrResourceNotFound) || errors.Is(err, ErrEnvVarNotFound) || errors.Is(err, ErrConfigVarNotFound)
+}
+
+// found turns the result of a lookup into the answer of an ExistenceCheck.
+func found[T any](_ T, err error) (bool, error) {
+	switch {
+	case notFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
 }
 
-func NewCloudClient(apiKey, endpoint string) CloudClient {
-	return &cloudClient{
-		httpClient: NewSturdyHTTPClient().
+// upsertable is a variable or tag, which upsert compares by value and
+// updates by ID.
+type upsertable interface {
+	row() (id int, value string)
+}
+
+func (v DeviceEnvVar) row() (int, string)        { return v.ID, v.Value }
+func (v FleetEnvVar) row() (int, string)         { return v.ID, v.Value }
+func (v ServiceEnvVar) row() (int, string)       { return v.ID, v.Value }
+func (v DeviceServiceEnvVar) row() (int, string) { return v.ID, v.Value }
+func (t DeviceTag) row() (int, string)           { return t.ID, t.Value }
+
+// upsert creates a variable or tag if get finds none, and otherwise updates
+// it unless it already holds value. When a concurrent writer creates it
+// first, create fails with ErrConflict and theirs is compared and updated
+// instead. It reports whether anything changed.
+func upsert[T upsertable](
+	ctx context.Context,
+	value string,
+	get func(ctx context.Context) (*T, error),
+	create func(ctx context.Context) error,
+	update func(ctx context.Context, id int) error,
+) (bool, error) {
+	current, err := get(ctx)
+	if notFound(err) {
+		err = create(ctx)
+		if !errors.Is(err, ErrConflict) {
+			return err == nil, err
+		}
+
+		current, err = get(ctx)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	id, currentValue := (*current).row()
+	if currentValue == value {
+		return false, nil
+	}
+
+	if err := update(ctx, id); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func NewCloudClient(apiKey, endpoint string, opts ...Option) CloudClient {
+	o := newClientOptions(opts)
+
+	pageSize := DefaultPageSize
+	if o.pageSize > 0 {
+		pageSize = o.pageSize
+	}
+
+	var client CloudClient = &cloudClient{
+		httpClient: o.newHTTPClient(apiKey).
 			SetBaseURL(endpoint).
 			SetHeader("Authorization", "Bearer "+apiKey),
+		pageSize:        pageSize,
+		logger:          loggerOrNop(o.logger),
+		resolver:        o.resolverCache,
+		resolverLookups: newResolverLookups(o.meterProvider),
+	}
+
+	if o.tracerProvider != nil {
+		client = newTracedCloudClient(client, o.tracerProvider)
 	}
+
+	return client
 }
 
 func (b *cloudClient) GetDevice(
 	ctx context.Context,
 	balenaDeviceUUID string,
 ) (*Device, error) {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return nil, ErrInvalidBalenaDeviceUUID
+	}
+
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[Device]{}).
-		Get("/v6/device(uuid='" + balenaDeviceUUID + "')?" + DeviceQuerySelector)
+		Get("/v6/device(uuid='" + balenaDeviceUUID + "')?" + deviceQuery().String())
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request to get device(%s) details: %w", balenaDeviceUUID, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting device(%s) details: %s", balenaDeviceUUID, response.Body())
+		return nil, fmt.Errorf("error getting device(%s) details: %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Response[Device])
@@ -110,13 +312,13 @@ func (b *cloudClient) GetDeviceEnvVarID(
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[DeviceEnvVar]{}).
-		Get("/v6/device_environment_variable?$filter=device%20eq%20" + strconv.Itoa(balenaDeviceID))
+		Get("/v6/device_environment_variable?" + NewQuery().Filter(Eq("device", balenaDeviceID)).String())
 	if err != nil {
 		return 0, fmt.Errorf("error getting device env var id for key(%s) on device(%d): %w", key, balenaDeviceID, err)
 	}
 
 	if response.IsError() {
-		return 0, fmt.Errorf("error getting device env var id for key(%s) on device(%d): response error: %s", key, balenaDeviceID, response.Body())
+		return 0, fmt.Errorf("error getting device env var id for key(%s) on device(%d): %w", key, balenaDeviceID, newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Response[DeviceEnvVar])
@@ -134,17 +336,20 @@ func (b *cloudClient) UpdateDeviceEnvVar(
 	balenaDeviceID, envVarID int,
 	value string,
 ) error {
-	var data = strings.NewReader(`{"value": "` + value + `"}`)
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
+
 	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(data).
-		Patch("/v6/device_environment_variable(" + strconv.Itoa(envVarID) + ")?$filter=device%20eq%20" + strconv.Itoa(balenaDeviceID))
+		SetContext(hideValues(ctx, value)).
+		SetBody(valueRequest{Value: value}).
+		Patch("/v6/device_environment_variable(" + strconv.Itoa(envVarID) + ")?" + NewQuery().Filter(Eq("device", balenaDeviceID)).String())
 	if err != nil {
 		return fmt.Errorf("failed performing request to update device(%d) env var(%d): %w", balenaDeviceID, envVarID, err)
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error updating device(%d) env var(%d): %s", balenaDeviceID, envVarID, response.Body())
+		return fmt.Errorf("error updating device(%d) env var(%d): %w", balenaDeviceID, envVarID, newAPIError(response))
 	}
 
 	return nil
@@ -161,13 +366,13 @@ func (b *cloudClient) GetDeviceDetails(
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[Device]{}).
-		Get("/v6/device(uuid='" + balenaDeviceUUID + "')?" + DeviceDetailsQuerySelector + "&" + DeviceQuerySelector)
+		Get("/v6/device(uuid='" + balenaDeviceUUID + "')?" + deviceDetailsQuery().String())
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request to get device(%s) details: %w", balenaDeviceUUID, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting device(%s) details: %s", balenaDeviceUUID, response.Body())
+		return nil, fmt.Errorf("error getting device(%s) details: %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Response[Device])
@@ -182,40 +387,226 @@ func (b *cloudClient) GetDeviceDetails(
 	return &balenaResult.D[0], nil
 }
 
+// devicesDetailsChunkSize bounds how many UUIDs go into the $filter of a
+// single request, keeping its URL well below common length limits.
+const devicesDetailsChunkSize = 50
+
+// maxConcurrentDetailsChunks bounds how many chunks GetDevicesDetails fetches
+// at once.
+const maxConcurrentDetailsChunks = 4
+
+// GetDevicesDetails returns the devices in the order of balenaDeviceUUIDs,
+// fetching large lists in chunks. When some UUIDs do not exist it returns
+// the devices found along with a *DevicesNotFoundError listing the others.
 func (b *cloudClient) GetDevicesDetails(
 	ctx context.Context,
 	balenaDeviceUUIDs []string,
 ) ([]Device, error) {
-	filter := ""
-	for i, uuid := range balenaDeviceUUIDs {
+	for _, uuid := range balenaDeviceUUIDs {
 		if !IsValidBalenaDeviceUUID(uuid) {
 			return nil, ErrInvalidBalenaDeviceUUID
 		}
+	}
 
-		filter += "'" + uuid + "'"
-		if i < len(balenaDeviceUUIDs)-1 {
-			filter += ","
+	chunks := slices.Collect(slices.Chunk(balenaDeviceUUIDs, devicesDetailsChunkSize))
+	results := make([][]Device, len(chunks))
+	errs := make([]error, len(chunks))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentDetailsChunks)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+
+			results[i], errs[i] = b.getDevicesDetailsChunk(ctx, chunk)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Report the error that caused the cancellation rather than the
+	// context.Canceled of the chunks it interrupted.
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, err
 		}
 	}
 
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetResult(Response[Device]{}).
-		Get("/v6/device?$filter=uuid%20in(" + filter + ")&" + DeviceDetailsQuerySelector + "&" + DeviceQuerySelector)
-	if err != nil {
-		return nil, fmt.Errorf("failed performing request to get devices(%s) details: %w", balenaDeviceUUIDs, err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	if response.IsError() {
-		return nil, fmt.Errorf("error getting devices(%s) details: status code(%d) %s", balenaDeviceUUIDs, response.StatusCode(), response.Body())
+	byUUID := make(map[string]Device, len(balenaDeviceUUIDs))
+	for _, chunk := range results {
+		for _, device := range chunk {
+			byUUID[device.UUID] = device
+		}
 	}
 
-	balenaResult := response.Result().(*Response[Device])
-	if len(balenaResult.D) == 0 {
-		return nil, ErrResourceNotFound
+	devices := make([]Device, 0, len(byUUID))
+	returned := make(map[string]bool, len(byUUID))
+	var missing []string
+	for _, uuid := range balenaDeviceUUIDs {
+		device, ok := byUUID[strings.ToLower(uuid)]
+		switch {
+		case !ok:
+			missing = append(missing, uuid)
+		case !returned[device.UUID]:
+			// Requested twice, returned once.
+			returned[device.UUID] = true
+			devices = append(devices, device)
+		}
 	}
 
-	return balenaResult.D, nil
+	if len(missing) > 0 {
+		return devices, &DevicesNotFoundError{UUIDs: missing}
+	}
+
+	return devices, nil
+}
+
+func (b *cloudClient) getDevicesDetailsChunk(
+	ctx context.Context,
+	balenaDeviceUUIDs []string,
+) ([]Device, error) {
+	query := deviceDetailsQuery().
+		Filter(In("uuid", balenaDeviceUUIDs...)).
+		OrderBy("id", Asc)
+
+	devices := make([]Device, 0, len(balenaDeviceUUIDs))
+	for device, err := range paginate[Device](ctx, b.httpClient, "/v6/device", query, devicesDetailsChunkSize) {
+		if err != nil {
+			return nil, fmt.Errorf("error getting devices(%s) details: %w", balenaDeviceUUIDs, err)
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// IterDevicesDetails is the paginated form of GetDevicesDetails. Unlike
+// GetDevicesDetails it silently skips devices that do not exist. Large lists
+// are fetched one chunk of devicesDetailsChunkSize UUIDs after the other.
+func (b *cloudClient) IterDevicesDetails(
+	ctx context.Context,
+	balenaDeviceUUIDs []string,
+) iter.Seq2[Device, error] {
+	for _, uuid := range balenaDeviceUUIDs {
+		if !IsValidBalenaDeviceUUID(uuid) {
+			return failed[Device](ErrInvalidBalenaDeviceUUID)
+		}
+	}
+
+	return func(yield func(Device, error) bool) {
+		for chunk := range slices.Chunk(balenaDeviceUUIDs, devicesDetailsChunkSize) {
+			query := deviceDetailsQuery().
+				Filter(In("uuid", chunk...)).
+				OrderBy("id", Asc)
+
+			for device, err := range paginate[Device](ctx, b.httpClient, "/v6/device", query, b.pageSize) {
+				if !yield(device, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ListDevicesOptions narrows down ListFleetDevices. Zero-valued fields are
+// not filtered on; set fields are combined with AND.
+type ListDevicesOptions struct {
+	IsOnline             *bool
+	OverallStatus        string
+	OsVersion            string
+	DeviceType           DeviceType
+	RunningReleaseID     int
+	RunningReleaseCommit string
+	// TagKey matches devices carrying the tag; TagValue additionally
+	// requires the tag to have that value.
+	TagKey   string
+	TagValue string
+}
+
+func (o ListDevicesOptions) filter() Filter {
+	var filters []Filter
+	if o.IsOnline != nil {
+		filters = append(filters, Eq("is_online", *o.IsOnline))
+	}
+
+	if o.OverallStatus != "" {
+		filters = append(filters, Eq("overall_status", o.OverallStatus))
+	}
+
+	if o.OsVersion != "" {
+		filters = append(filters, Eq("os_version", o.OsVersion))
+	}
+
+	if o.DeviceType != "" {
+		filters = append(filters, Eq("is_of__device_type/slug", o.DeviceType))
+	}
+
+	if o.RunningReleaseID != 0 {
+		filters = append(filters, Eq("is_running__release", o.RunningReleaseID))
+	}
+
+	if o.RunningReleaseCommit != "" {
+		filters = append(filters, Eq("is_running__release/commit", o.RunningReleaseCommit))
+	}
+
+	if o.TagKey != "" {
+		tag := Eq("dt/tag_key", o.TagKey)
+		if o.TagValue != "" {
+			tag = And(tag, Eq("dt/value", o.TagValue))
+		}
+		filters = append(filters, Any("device_tag", "dt", tag))
+	}
+
+	return And(filters...)
+}
+
+// ListFleetDevices returns the devices of a fleet with the same detail as
+// GetDeviceDetails. Devices are fetched page by page, so large fleets do not
+// hit request timeouts.
+func (b *cloudClient) ListFleetDevices(
+	ctx context.Context,
+	fleetName string,
+	opts ListDevicesOptions,
+) ([]Device, error) {
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+	}
+
+	query := deviceDetailsQuery().
+		Filter(Eq("belongs_to__application", fleetID)).
+		Filter(opts.filter()).
+		OrderBy("id", Asc)
+
+	devices := make([]Device, 0)
+	for device, err := range paginate[Device](ctx, b.httpClient, "/v6/device", query, b.pageSize) {
+		if err != nil {
+			return nil, fmt.Errorf("error listing fleet(%s) devices: %w", fleetName, err)
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
 }
 
 func (b *cloudClient) GetDeviceID(
@@ -226,41 +617,99 @@ func (b *cloudClient) GetDeviceID(
 		return 0, ErrInvalidBalenaDeviceUUID
 	}
 
+	return b.resolve(ctx, deviceKey(balenaDeviceUUID), func() (int, error) {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetResult(Response[DeviceID]{}).
+			Get("/v6/device(uuid='" + balenaDeviceUUID + "')?" + NewQuery().Select("id").String())
+		if err != nil {
+			return 0, fmt.Errorf("failed performing request to get device(%s) ID: %w", balenaDeviceUUID, err)
+		}
+
+		if response.IsError() {
+			return 0, fmt.Errorf("error getting device(%s) ID: %w", balenaDeviceUUID, newAPIError(response))
+		}
+
+		balenaResult := response.Result().(*Response[DeviceID])
+		if len(balenaResult.D) == 0 {
+			return 0, ErrResourceNotFound
+		}
+
+		if len(balenaResult.D) > 1 {
+			return 0, ErrExpectedOneResult
+		}
+
+		return balenaResult.D[0].ID, nil
+	})
+}
+
+// ResolveDevice returns the full UUID of the device ref refers to, be it by
+// its full UUID, a prefix of at least minShortUUIDLength characters such as
+// the short UUID shown in the dashboard, its name or its numeric ID. A ref
+// matching several devices, e.g. a name shared by two devices, yields an
+// *AmbiguousDeviceError.
+func (b *cloudClient) ResolveDevice(ctx context.Context, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if IsValidBalenaDeviceUUID(ref) {
+		return strings.ToLower(ref), nil
+	}
+
+	if ref == "" {
+		return "", ErrInvalidBalenaDeviceUUID
+	}
+
+	filters := []Filter{Eq("device_name", ref)}
+	if isShortUUID(ref) {
+		filters = append(filters, StartsWith("uuid", strings.ToLower(ref)))
+	}
+
+	if id, err := strconv.Atoi(ref); err == nil && id > 0 {
+		filters = append(filters, Eq("id", id))
+	}
+
 	response, err := b.httpClient.R().
 		SetContext(ctx).
-		SetResult(Response[DeviceID]{}).
-		Get("/v6/device(uuid='" + balenaDeviceUUID + "')?$select=id")
+		SetResult(Response[Device]{}).
+		Get("/v6/device?" + NewQuery().
+			Filter(Or(filters...)).
+			Select("id", "uuid", "device_name").
+			Top(maxAmbiguousMatches).
+			String())
 	if err != nil {
-		return 0, fmt.Errorf("failed performing request to get device(%s) ID: %w", balenaDeviceUUID, err)
+		return "", fmt.Errorf("failed performing request to resolve device(%s): %w", ref, err)
 	}
 
 	if response.IsError() {
-		return 0, fmt.Errorf("error getting device(%s) ID: %s", balenaDeviceUUID, response.Body())
+		return "", fmt.Errorf("error resolving device(%s): %w", ref, newAPIError(response))
 	}
 
-	balenaResult := response.Result().(*Response[DeviceID])
-	if len(balenaResult.D) == 0 {
-		return 0, ErrResourceNotFound
+	devices := response.Result().(*Response[Device]).D
+	if len(devices) == 0 {
+		return "", fmt.Errorf("no device matches %q: %w", ref, ErrResourceNotFound)
 	}
 
-	if len(balenaResult.D) > 1 {
-		return 0, ErrExpectedOneResult
+	if len(devices) > 1 {
+		return "", &AmbiguousDeviceError{Ref: ref, Matches: devices}
 	}
 
-	return balenaResult.D[0].ID, nil
+	if b.resolver != nil {
+		b.resolver.set(deviceKey(devices[0].UUID), devices[0].ID)
+	}
+
+	return devices[0].UUID, nil
 }
 
 func (b *cloudClient) GetFleet(ctx context.Context, name string) (*Fleet, error) {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[Fleet]{}).
-		Get("/v6/application?$filter=app_name%20eq%20'" + name + "'")
+		Get("/v6/application?" + NewQuery().Filter(Eq("app_name", name)).String())
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request to get fleet(%s): %w", name, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting fleet(%s): %s", name, response.Body())
+		return nil, fmt.Errorf("error getting fleet(%s): %w", name, newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Response[Fleet])
@@ -275,190 +724,775 @@ func (b *cloudClient) GetFleet(ctx context.Context, name string) (*Fleet, error)
 	return &balenaResult.D[0], nil
 }
 
+// getFleetID returns the ID of the named fleet, from the resolver cache if
+// possible.
+func (b *cloudClient) getFleetID(ctx context.Context, name string) (int, error) {
+	return b.resolve(ctx, fleetKey(name), func() (int, error) {
+		fleet, err := b.GetFleet(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+
+		return fleet.ID, nil
+	})
+}
+
 func (b *cloudClient) RegisterDevice(
 	ctx context.Context,
 	balenaDeviceUUID, fleetName string,
 	deviceType DeviceType,
 ) error {
-	fleet, err := b.GetFleet(ctx, fleetName)
+	fleetID, err := b.getFleetID(ctx, fleetName)
 	if err != nil {
 		return fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
 	}
 
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(b.GetDeviceID(ctx, balenaDeviceUUID))
+	})
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(map[string]interface{}{
-			"application": fleet.ID,
+			"application": fleetID,
 			"uuid":        balenaDeviceUUID,
 			"device_type": string(deviceType),
 		}).
 		Post("/device/register")
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed performing request to register device(%s) request: %w", balenaDeviceUUID, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error while registering device: %w", newAPIError(response))
+	}
+
+	return nil
+}
+
+func (b *cloudClient) DeleteDevice(
+	ctx context.Context,
+	balenaDeviceUUID string,
+) error {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete("/v6/device(" + strconv.Itoa(id) + ")")
+	if err != nil {
+		return fmt.Errorf("failed performing request to delete device(%s): %w", balenaDeviceUUID, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error deleting device(%s): %w", balenaDeviceUUID, newAPIError(response))
+	}
+
+	b.resolver.invalidate(deviceKey(balenaDeviceUUID))
+
+	return nil
+}
+
+func (b *cloudClient) GetDeviceEnvVars(
+	ctx context.Context,
+	balenaDeviceUUID string,
+) ([]DeviceEnvVar, error) {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return nil, ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[DeviceEnvVar]{}).
+		Get("/v6/device_environment_variable?" + NewQuery().Filter(Eq("device", id)).String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request for getting device(%s) env vars: %w", balenaDeviceUUID, err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting device(%s) env vars: %w", balenaDeviceUUID, newAPIError(response))
+	}
+
+	return response.Result().(*Response[DeviceEnvVar]).D, nil
+}
+
+func (b *cloudClient) IterDeviceEnvVars(
+	ctx context.Context,
+	balenaDeviceUUID string,
+) iter.Seq2[DeviceEnvVar, error] {
+	return func(yield func(DeviceEnvVar, error) bool) {
+		if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+			yield(DeviceEnvVar{}, ErrInvalidBalenaDeviceUUID)
+			return
+		}
+
+		id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+		if err != nil {
+			yield(DeviceEnvVar{}, fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err))
+			return
+		}
+
+		query := NewQuery().
+			Filter(Eq("device", id)).
+			OrderBy("id", Asc)
+
+		for envVar, err := range paginate[DeviceEnvVar](ctx, b.httpClient, "/v6/device_environment_variable", query, b.pageSize) {
+			if !yield(envVar, err) {
+				return
+			}
+		}
+	}
+}
+
+func (b *cloudClient) CreateDeviceEnvVar(
+	ctx context.Context, balenaDeviceUUID, key string, value string,
+) error {
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
+
+	b.observeEnvVar(key, value)
+
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	return b.createDeviceEnvVar(ctx, balenaDeviceUUID, id, key, value)
+}
+
+func (b *cloudClient) createDeviceEnvVar(
+	ctx context.Context,
+	balenaDeviceUUID string,
+	deviceID int,
+	name, value string,
+) error {
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(b.getDeviceEnvVar(ctx, deviceID, name))
+	})
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"device": deviceID,
+			"name":   name,
+			"value":  value,
+		}).
+		Post("/v6/device_environment_variable")
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed performing request to create device(%s) env var(%s): %w", balenaDeviceUUID, name, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error creating device(%s) env var(%s): %w", balenaDeviceUUID, name, newAPIError(response))
+	}
+
+	return nil
+}
+
+func (b *cloudClient) DeleteDeviceEnvVar(
+	ctx context.Context, balenaDeviceID, envVarID int,
+) error {
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete("/v6/device_environment_variable(" + strconv.Itoa(envVarID) + ")?" + NewQuery().Filter(Eq("device", balenaDeviceID)).String())
+	if err != nil {
+		return fmt.Errorf("failed performing request to delete device(%d) env var(%d): %w", balenaDeviceID, envVarID, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error deleting device(%d) env var(%d): %w", balenaDeviceID, envVarID, newAPIError(response))
+	}
+
+	return nil
+}
+
+func (b *cloudClient) getDeviceEnvVar(
+	ctx context.Context,
+	balenaDeviceID int,
+	name string,
+) (*DeviceEnvVar, error) {
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[DeviceEnvVar]{}).
+		Get("/v6/device_environment_variable?" + NewQuery().
+			Filter(And(Eq("device", balenaDeviceID), Eq("name", name))).
+			String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request to get device(%d) env var(%s): %w", balenaDeviceID, name, err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting device(%d) env var(%s): %w", balenaDeviceID, name, newAPIError(response))
+	}
+
+	balenaResult := response.Result().(*Response[DeviceEnvVar])
+	if len(balenaResult.D) == 0 {
+		return nil, ErrEnvVarNotFound
+	}
+
+	return &balenaResult.D[0], nil
+}
+
+// SetDeviceEnvVar creates or updates a device variable by name. It reports
+// whether anything changed: setting a variable to its current value is a
+// no-op that returns false.
+func (b *cloudClient) SetDeviceEnvVar(
+	ctx context.Context,
+	balenaDeviceUUID, name, value string,
+) (bool, error) {
+	if !utf8.ValidString(value) {
+		return false, ErrInvalidValue
+	}
+
+	b.observeEnvVar(name, value)
+
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return false, ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return false, fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	return upsert(ctx, value,
+		func(ctx context.Context) (*DeviceEnvVar, error) {
+			return b.getDeviceEnvVar(ctx, id, name)
+		},
+		func(ctx context.Context) error {
+			return b.createDeviceEnvVar(ctx, balenaDeviceUUID, id, name, value)
+		},
+		func(ctx context.Context, envVarID int) error {
+			response, err := b.httpClient.R().
+				SetContext(ctx).
+				SetBody(valueRequest{Value: value}).
+				Patch("/v6/device_environment_variable(" + strconv.Itoa(envVarID) + ")")
+			if err != nil {
+				return fmt.Errorf("failed performing request to update device(%s) env var(%s): %w", balenaDeviceUUID, name, err)
+			}
+
+			if response.IsError() {
+				return fmt.Errorf("error updating device(%s) env var(%s): %w", balenaDeviceUUID, name, newAPIError(response))
+			}
+
+			return nil
+		})
+}
+
+func (b *cloudClient) GetFleetEnvVars(
+	ctx context.Context,
+	name string,
+) ([]FleetEnvVar, error) {
+	if name == "" {
+		return nil, fmt.Errorf("fleet name is required")
+	}
+
+	fleetID, err := b.getFleetID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[FleetEnvVar]{}).
+		Get("/v6/application_environment_variable?" + NewQuery().Filter(Eq("application", fleetID)).String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request for getting fleet(%s) env vars: %w", name, err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting fleet(%s) env vars: %w", name, newAPIError(response))
+	}
+
+	return response.Result().(*Response[FleetEnvVar]).D, nil
+}
+
+func (b *cloudClient) IterFleetEnvVars(
+	ctx context.Context,
+	name string,
+) iter.Seq2[FleetEnvVar, error] {
+	return func(yield func(FleetEnvVar, error) bool) {
+		if name == "" {
+			yield(FleetEnvVar{}, fmt.Errorf("fleet name is required"))
+			return
+		}
+
+		fleetID, err := b.getFleetID(ctx, name)
+		if err != nil {
+			yield(FleetEnvVar{}, err)
+			return
+		}
+
+		query := NewQuery().
+			Filter(Eq("application", fleetID)).
+			OrderBy("id", Asc)
+
+		for envVar, err := range paginate[FleetEnvVar](ctx, b.httpClient, "/v6/application_environment_variable", query, b.pageSize) {
+			if !yield(envVar, err) {
+				return
+			}
+		}
+	}
+}
+
+func (b *cloudClient) getFleetEnvVar(
+	ctx context.Context,
+	fleetID int,
+	name string,
+) (*FleetEnvVar, error) {
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[FleetEnvVar]{}).
+		Get("/v6/application_environment_variable?" + NewQuery().
+			Filter(And(Eq("application", fleetID), Eq("name", name))).
+			String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request to get fleet(%d) env var(%s): %w", fleetID, name, err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting fleet(%d) env var(%s): %w", fleetID, name, newAPIError(response))
+	}
+
+	balenaResult := response.Result().(*Response[FleetEnvVar])
+	if len(balenaResult.D) == 0 {
+		return nil, ErrEnvVarNotFound
+	}
+
+	return &balenaResult.D[0], nil
+}
+
+func (b *cloudClient) CreateFleetEnvVar(
+	ctx context.Context,
+	fleetName, name, value string,
+) error {
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
+
+	b.observeEnvVar(name, value)
+
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+	}
+
+	return b.createFleetEnvVar(ctx, fleetID, fleetName, name, value)
+}
+
+func (b *cloudClient) createFleetEnvVar(
+	ctx context.Context,
+	fleetID int,
+	fleetName, name, value string,
+) error {
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(b.getFleetEnvVar(ctx, fleetID, name))
+	})
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"application": fleetID,
+			"name":        name,
+			"value":       value,
+		}).
+		Post("/v6/application_environment_variable")
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed performing request to create fleet(%s) env var(%s): %w", fleetName, name, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error creating fleet(%s) env var(%s): %w", fleetName, name, newAPIError(response))
+	}
+
+	return nil
+}
+
+// UpdateFleetEnvVar changes the value of an existing fleet variable and
+// returns ErrEnvVarNotFound if the fleet has no variable with that name.
+func (b *cloudClient) UpdateFleetEnvVar(
+	ctx context.Context,
+	fleetName, name, value string,
+) error {
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
+
+	b.observeEnvVar(name, value)
+
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+	}
+
+	envVar, err := b.getFleetEnvVar(ctx, fleetID, name)
+	if err != nil {
+		return fmt.Errorf("failed getting fleet(%s) env var(%s): %w", fleetName, name, err)
+	}
+
+	return b.updateFleetEnvVar(ctx, fleetName, envVar.ID, value)
+}
+
+func (b *cloudClient) updateFleetEnvVar(
+	ctx context.Context,
+	fleetName string,
+	envVarID int,
+	value string,
+) error {
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetBody(valueRequest{Value: value}).
+		Patch("/v6/application_environment_variable(" + strconv.Itoa(envVarID) + ")")
+	if err != nil {
+		return fmt.Errorf("failed performing request to update fleet(%s) env var(%d): %w", fleetName, envVarID, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error updating fleet(%s) env var(%d): %w", fleetName, envVarID, newAPIError(response))
+	}
+
+	return nil
+}
+
+// UpsertFleetEnvVar sets a fleet variable, creating it if it does not exist,
+// and reports whether anything changed.
+func (b *cloudClient) UpsertFleetEnvVar(
+	ctx context.Context,
+	fleetName, name, value string,
+) (bool, error) {
+	if !utf8.ValidString(value) {
+		return false, ErrInvalidValue
+	}
+
+	b.observeEnvVar(name, value)
+
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return false, fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+	}
+
+	return upsert(ctx, value,
+		func(ctx context.Context) (*FleetEnvVar, error) {
+			return b.getFleetEnvVar(ctx, fleetID, name)
+		},
+		func(ctx context.Context) error {
+			return b.createFleetEnvVar(ctx, fleetID, fleetName, name, value)
+		},
+		func(ctx context.Context, envVarID int) error {
+			return b.updateFleetEnvVar(ctx, fleetName, envVarID, value)
+		})
+}
+
+// DeleteFleetEnvVar removes a fleet variable and returns ErrEnvVarNotFound if
+// the fleet has no variable with that name.
+func (b *cloudClient) DeleteFleetEnvVar(
+	ctx context.Context,
+	fleetName, name string,
+) error {
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+	}
+
+	envVar, err := b.getFleetEnvVar(ctx, fleetID, name)
+	if err != nil {
+		return fmt.Errorf("failed getting fleet(%s) env var(%s): %w", fleetName, name, err)
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete("/v6/application_environment_variable(" + strconv.Itoa(envVar.ID) + ")")
+	if err != nil {
+		return fmt.Errorf("failed performing request to delete fleet(%s) env var(%s): %w", fleetName, name, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error deleting fleet(%s) env var(%s): %w", fleetName, name, newAPIError(response))
+	}
+
+	return nil
+}
+
+func (b *cloudClient) GetServiceEnvVars(
+	ctx context.Context,
+	fleetName string,
+) ([]ServiceEnvVar, error) {
+	if fleetName == "" {
+		return nil, fmt.Errorf("fleet name is required")
+	}
+
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[ServiceEnvVar]{}).
+		Get("/v6/service_environment_variable?" + serviceEnvVarsQuery(fleetID).String())
 	if err != nil {
-		return fmt.Errorf("failed performing request to register device(%s) request: %w", balenaDeviceUUID, err)
+		return nil, fmt.Errorf("failed performing request for getting service fleet(%s) env vars: %w", fleetName, err)
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error while registering device: %s", response.Body())
+		return nil, fmt.Errorf("error getting service fleet(%s) env vars: %w", fleetName, newAPIError(response))
 	}
 
-	return nil
+	return response.Result().(*Response[ServiceEnvVar]).D, nil
 }
 
-func (b *cloudClient) DeleteDevice(
+func (b *cloudClient) getServiceID(
 	ctx context.Context,
-	balenaDeviceUUID string,
-) error {
-	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
-		return ErrInvalidBalenaDeviceUUID
-	}
-
-	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
-	if err != nil {
-		return fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
-	}
+	fleetID int,
+	serviceName string,
+) (int, error) {
+	return b.resolve(ctx, serviceKey(fleetID, serviceName), func() (int, error) {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetResult(Response[ServiceShort]{}).
+			Get("/v6/service?" + NewQuery().
+				Filter(And(Eq("application", fleetID), Eq("service_name", serviceName))).
+				Select("id", "service_name").
+				String())
+		if err != nil {
+			return 0, fmt.Errorf("failed performing request to get fleet(%d) service(%s): %w", fleetID, serviceName, err)
+		}
 
-	response, err := b.httpClient.R().Delete("/v6/device(" + strconv.Itoa(id) + ")")
-	if err != nil {
-		return fmt.Errorf("failed performing request to delete device(%s): %w", balenaDeviceUUID, err)
-	}
+		if response.IsError() {
+			return 0, fmt.Errorf("error getting fleet(%d) service(%s): %w", fleetID, serviceName, newAPIError(response))
+		}
 
-	if response.IsError() {
-		return fmt.Errorf("error deleting device(%s): %s", balenaDeviceUUID, response.Body())
-	}
+		balenaResult := response.Result().(*Response[ServiceShort])
+		if len(balenaResult.D) == 0 {
+			return 0, ErrServiceNotFound
+		}
 
-	return nil
+		return balenaResult.D[0].ID, nil
+	})
 }
 
-func (b *cloudClient) GetDeviceEnvVars(
+// resolveService returns the ID of a service of the named fleet.
+func (b *cloudClient) resolveService(
 	ctx context.Context,
-	balenaDeviceUUID string,
-) ([]DeviceEnvVar, error) {
-	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
-		return nil, ErrInvalidBalenaDeviceUUID
+	fleetName, serviceName string,
+) (int, error) {
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return 0, fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
 	}
 
-	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	serviceID, err := b.getServiceID(ctx, fleetID, serviceName)
 	if err != nil {
-		return nil, fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+		return 0, fmt.Errorf("failed getting fleet(%s) service(%s): %w", fleetName, serviceName, err)
 	}
 
+	return serviceID, nil
+}
+
+func (b *cloudClient) getServiceEnvVar(
+	ctx context.Context,
+	serviceID int,
+	name string,
+) (*ServiceEnvVar, error) {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
-		SetResult(Response[DeviceEnvVar]{}).
-		Get("/v6/device_environment_variable?$filter=device%20eq%20" + strconv.Itoa(id))
+		SetResult(Response[ServiceEnvVar]{}).
+		Get("/v6/service_environment_variable?" + NewQuery().
+			Filter(And(Eq("service", serviceID), Eq("name", name))).
+			Select("id", "name", "value").
+			String())
 	if err != nil {
-		return nil, fmt.Errorf("failed performing request for getting device(%s) env vars: %w", balenaDeviceUUID, err)
+		return nil, fmt.Errorf("failed performing request to get service(%d) env var(%s): %w", serviceID, name, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting device(%s) env vars: %s", balenaDeviceUUID, response.Body())
+		return nil, fmt.Errorf("error getting service(%d) env var(%s): %w", serviceID, name, newAPIError(response))
 	}
 
-	return response.Result().(*Response[DeviceEnvVar]).D, nil
+	balenaResult := response.Result().(*Response[ServiceEnvVar])
+	if len(balenaResult.D) == 0 {
+		return nil, ErrEnvVarNotFound
+	}
+
+	return &balenaResult.D[0], nil
 }
 
-func (b *cloudClient) CreateDeviceEnvVar(
-	ctx context.Context, balenaDeviceUUID, key string, value string,
+func (b *cloudClient) CreateServiceEnvVar(
+	ctx context.Context,
+	fleetName, serviceName, name, value string,
 ) error {
-	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
-		return ErrInvalidBalenaDeviceUUID
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
 	}
 
-	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	b.observeEnvVar(name, value)
+
+	serviceID, err := b.resolveService(ctx, fleetName, serviceName)
 	if err != nil {
-		return fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+		return err
 	}
 
+	return b.createServiceEnvVar(ctx, serviceID, name, value)
+}
+
+func (b *cloudClient) createServiceEnvVar(
+	ctx context.Context,
+	serviceID int,
+	name, value string,
+) error {
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(b.getServiceEnvVar(ctx, serviceID, name))
+	})
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(map[string]interface{}{
-			"device": id,
-			"name":   key,
-			"value":  value,
+			"service": serviceID,
+			"name":    name,
+			"value":   value,
 		}).
-		Post("/v6/device_environment_variable")
+		Post("/v6/service_environment_variable")
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
 	if err != nil {
-		return fmt.Errorf("failed performing request to create device(%s) env var(%s): %w", balenaDeviceUUID, key, err)
+		return fmt.Errorf("failed performing request to create service(%d) env var(%s): %w", serviceID, name, err)
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error creating device(%s) env var(%s): %s", balenaDeviceUUID, key, response.Body())
+		return fmt.Errorf("error creating service(%d) env var(%s): %w", serviceID, name, newAPIError(response))
 	}
 
 	return nil
 }
 
-func (b *cloudClient) DeleteDeviceEnvVar(
-	ctx context.Context, balenaDeviceID, envVarID int,
+// UpdateServiceEnvVar changes the value of an existing service variable and
+// returns ErrEnvVarNotFound if the service has no variable with that name.
+func (b *cloudClient) UpdateServiceEnvVar(
+	ctx context.Context,
+	fleetName, serviceName, name, value string,
 ) error {
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		Delete("/v6/device_environment_variable(" + strconv.Itoa(envVarID) + ")?$filter=device%20eq%20" + strconv.Itoa(balenaDeviceID))
-	if err != nil {
-		return fmt.Errorf("failed performing request to delete device(%d) env var(%d): %w", balenaDeviceID, envVarID, err)
-	}
-
-	if response.IsError() {
-		return fmt.Errorf("error deleting device(%d) env var(%d): %s", balenaDeviceID, envVarID, response.Body())
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
 	}
 
-	return nil
-}
+	b.observeEnvVar(name, value)
 
-func (b *cloudClient) GetFleetEnvVars(
-	ctx context.Context,
-	name string,
-) ([]FleetEnvVar, error) {
-	if name == "" {
-		return nil, fmt.Errorf("fleet name is required")
+	serviceID, err := b.resolveService(ctx, fleetName, serviceName)
+	if err != nil {
+		return err
 	}
 
-	fleet, err := b.GetFleet(ctx, name)
+	envVar, err := b.getServiceEnvVar(ctx, serviceID, name)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed getting fleet(%s) service(%s) env var(%s): %w", fleetName, serviceName, name, err)
 	}
 
+	return b.updateServiceEnvVar(ctx, envVar.ID, value)
+}
+
+func (b *cloudClient) updateServiceEnvVar(
+	ctx context.Context,
+	envVarID int,
+	value string,
+) error {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
-		SetResult(Response[FleetEnvVar]{}).
-		Get("/v6/application_environment_variable?$filter=application%20eq%20" + strconv.Itoa(fleet.ID))
+		SetBody(valueRequest{Value: value}).
+		Patch("/v6/service_environment_variable(" + strconv.Itoa(envVarID) + ")")
 	if err != nil {
-		return nil, fmt.Errorf("failed performing request for getting fleet(%s) env vars: %w", name, err)
+		return fmt.Errorf("failed performing request to update service env var(%d): %w", envVarID, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting fleet(%s) env vars: %s", name, response.Body())
+		return fmt.Errorf("error updating service env var(%d): %w", envVarID, newAPIError(response))
 	}
 
-	return response.Result().(*Response[FleetEnvVar]).D, nil
+	return nil
 }
 
-func (b *cloudClient) GetServiceEnvVars(
+// UpsertServiceEnvVar sets a service variable, creating it if it does not
+// exist, and reports whether anything changed.
+func (b *cloudClient) UpsertServiceEnvVar(
 	ctx context.Context,
-	fleetName string,
-) ([]ServiceEnvVar, error) {
-	if fleetName == "" {
-		return nil, fmt.Errorf("fleet name is required")
+	fleetName, serviceName, name, value string,
+) (bool, error) {
+	if !utf8.ValidString(value) {
+		return false, ErrInvalidValue
 	}
 
-	fleet, err := b.GetFleet(ctx, fleetName)
+	b.observeEnvVar(name, value)
+
+	serviceID, err := b.resolveService(ctx, fleetName, serviceName)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+
+	return upsert(ctx, value,
+		func(ctx context.Context) (*ServiceEnvVar, error) {
+			return b.getServiceEnvVar(ctx, serviceID, name)
+		},
+		func(ctx context.Context) error {
+			return b.createServiceEnvVar(ctx, serviceID, name, value)
+		},
+		func(ctx context.Context, envVarID int) error {
+			return b.updateServiceEnvVar(ctx, envVarID, value)
+		})
+}
+
+// DeleteServiceEnvVar removes a service variable and returns
+// ErrEnvVarNotFound if the service has no variable with that name.
+func (b *cloudClient) DeleteServiceEnvVar(
+	ctx context.Context,
+	fleetName, serviceName, name string,
+) error {
+	serviceID, err := b.resolveService(ctx, fleetName, serviceName)
+	if err != nil {
+		return err
+	}
+
+	envVar, err := b.getServiceEnvVar(ctx, serviceID, name)
+	if err != nil {
+		return fmt.Errorf("failed getting fleet(%s) service(%s) env var(%s): %w", fleetName, serviceName, name, err)
 	}
 
 	response, err := b.httpClient.R().
 		SetContext(ctx).
-		SetResult(Response[ServiceEnvVar]{}).
-		Get("/v6/service_environment_variable?$filter=service/any(s:s/application%20eq%20" + strconv.Itoa(fleet.ID) + ")" + "&$select=id,name,value&$expand=service($select=id,service_name)")
+		Delete("/v6/service_environment_variable(" + strconv.Itoa(envVar.ID) + ")")
 	if err != nil {
-		return nil, fmt.Errorf("failed performing request for getting service fleet(%s) env vars: %w", fleetName, err)
+		return fmt.Errorf("failed performing request to delete fleet(%s) service(%s) env var(%s): %w", fleetName, serviceName, name, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting service fleet(%s) env vars: %s", fleetName, response.Body())
+		return fmt.Errorf("error deleting fleet(%s) service(%s) env var(%s): %w", fleetName, serviceName, name, newAPIError(response))
 	}
 
-	return response.Result().(*Response[ServiceEnvVar]).D, nil
+	return nil
 }
 
 func (b *cloudClient) GetDeviceServiceInstallIDs(
@@ -469,13 +1503,16 @@ func (b *cloudClient) GetDeviceServiceInstallIDs(
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[ServiceInstallResp]{}).
-		Get("/v7/service_install?$filter=device/uuid%20eq%20'" + balenaDeviceUUID + "'&$expand=installs__service($select=*)")
+		Get("/v7/service_install?" + NewQuery().
+			Filter(Eq("device/uuid", balenaDeviceUUID)).
+			Expand("installs__service", NewQuery().Select("*")).
+			String())
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request for getting device(%s) service install IDs: %w", balenaDeviceUUID, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting device service install IDs for device (%s): %s", balenaDeviceUUID, response.Body())
+		return nil, fmt.Errorf("error getting device service install IDs for device (%s): %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	balenaResult, ok := response.Result().(*Response[ServiceInstallResp])
@@ -488,6 +1525,7 @@ func (b *cloudClient) GetDeviceServiceInstallIDs(
 		for _, installService := range serviceInstall.InstallsService {
 			services = append(services, DeviceServiceInstall{
 				ServiceInstallID: serviceInstall.ServiceInstallID,
+				ServiceID:        installService.ServiceID,
 				ServiceName:      installService.ServiceName,
 			})
 		}
@@ -499,10 +1537,19 @@ func (b *cloudClient) GetDeviceServiceInstallIDs(
 func (b *cloudClient) CreateDeviceServiceEnvVar(
 	ctx context.Context, balenaDeviceUUID, name string, serviceInstallID int, value string,
 ) error {
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
+
+	b.observeEnvVar(name, value)
+
 	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
 		return ErrInvalidBalenaDeviceUUID
 	}
 
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(b.getDeviceServiceEnvVar(ctx, serviceInstallID, name))
+	})
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(map[string]interface{}{
@@ -511,12 +1558,16 @@ func (b *cloudClient) CreateDeviceServiceEnvVar(
 			"value":           value,
 		}).
 		Post("/v6/device_service_environment_variable")
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
 	if err != nil {
 		return fmt.Errorf("failed performing request to create device service env var for device (%s) with name (%s): %w", balenaDeviceUUID, name, err)
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error creating device service env var for device (%s) with name (%s): %s", balenaDeviceUUID, name, response.Body())
+		return fmt.Errorf("error creating device service env var for device (%s) with name (%s): %w", balenaDeviceUUID, name, newAPIError(response))
 	}
 
 	return nil
@@ -538,13 +1589,13 @@ func (b *cloudClient) GetDeviceServiceEnvVars(
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[DeviceServiceEnvVar]{}).
-		Get("/v6/device_service_environment_variable?$filter=service_install/any(si:si/device%20eq%20" + strconv.Itoa(id) + ")" + "&$select=id,name,value&$expand=service_install($select=id;$expand=installs__service($select=id,service_name))")
+		Get("/v6/device_service_environment_variable?" + deviceServiceEnvVarsQuery(id).String())
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request for getting device(%s) service fleet env vars: %w", balenaDeviceUUID, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting device(%s) service fleet env vars: %s", balenaDeviceUUID, response.Body())
+		return nil, fmt.Errorf("error getting device(%s) service fleet env vars: %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Response[DeviceServiceEnvVar])
@@ -554,29 +1605,117 @@ func (b *cloudClient) GetDeviceServiceEnvVars(
 func (b *cloudClient) UpdateDeviceServiceEnvVar(
 	ctx context.Context, balenaDeviceID, envVarID int, value string,
 ) error {
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
 
 	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(map[string]interface{}{
-			"id":    envVarID,
-			"value": value,
-		}).
-		Patch("/v6/device_service_environment_variable(" + strconv.Itoa(envVarID) + ")?$filter=service_install/any(si:si/device%20eq%20" + strconv.Itoa(balenaDeviceID) + ")")
+		SetContext(hideValues(ctx, value)).
+		SetBody(valueRequest{Value: value}).
+		Patch("/v6/device_service_environment_variable(" + strconv.Itoa(envVarID) + ")?" + NewQuery().Filter(deviceServiceInstallFilter(balenaDeviceID)).String())
 	if err != nil {
 		return fmt.Errorf("failed performing request to update device(%d) service env var(%d): %w", balenaDeviceID, envVarID, err)
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error updating device(%d) service env var(%d): %s", balenaDeviceID, envVarID, response.Body())
+		return fmt.Errorf("error updating device(%d) service env var(%d): %w", balenaDeviceID, envVarID, newAPIError(response))
 	}
 
 	return nil
 }
 
+func (b *cloudClient) getDeviceServiceEnvVar(
+	ctx context.Context,
+	serviceInstallID int,
+	name string,
+) (*DeviceServiceEnvVar, error) {
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[DeviceServiceEnvVar]{}).
+		Get("/v6/device_service_environment_variable?" + NewQuery().
+			Filter(And(Eq("service_install", serviceInstallID), Eq("name", name))).
+			Select("id", "name", "value").
+			String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request to get service install(%d) env var(%s): %w", serviceInstallID, name, err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting service install(%d) env var(%s): %w", serviceInstallID, name, newAPIError(response))
+	}
+
+	balenaResult := response.Result().(*Response[DeviceServiceEnvVar])
+	if len(balenaResult.D) == 0 {
+		return nil, ErrEnvVarNotFound
+	}
+
+	return &balenaResult.D[0], nil
+}
+
+// SetDeviceServiceEnvVar creates or updates a device service variable by
+// service and variable name, reporting whether anything changed. It returns
+// ErrServiceNotFound if the service is not installed on the device.
+func (b *cloudClient) SetDeviceServiceEnvVar(
+	ctx context.Context,
+	balenaDeviceUUID, serviceName, name, value string,
+) (bool, error) {
+	if !utf8.ValidString(value) {
+		return false, ErrInvalidValue
+	}
+
+	b.observeEnvVar(name, value)
+
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return false, ErrInvalidBalenaDeviceUUID
+	}
+
+	services, err := b.GetDeviceServiceInstallIDs(ctx, balenaDeviceUUID)
+	if err != nil {
+		return false, fmt.Errorf("failed getting device(%s) service installs: %w", balenaDeviceUUID, err)
+	}
+
+	serviceInstallID := 0
+	for _, service := range services {
+		if service.ServiceName == serviceName {
+			serviceInstallID = service.ServiceInstallID
+			break
+		}
+	}
+
+	if serviceInstallID == 0 {
+		return false, fmt.Errorf("failed getting device(%s) service(%s) install: %w", balenaDeviceUUID, serviceName, ErrServiceNotFound)
+	}
+
+	return upsert(ctx, value,
+		func(ctx context.Context) (*DeviceServiceEnvVar, error) {
+			return b.getDeviceServiceEnvVar(ctx, serviceInstallID, name)
+		},
+		func(ctx context.Context) error {
+			return b.CreateDeviceServiceEnvVar(ctx, balenaDeviceUUID, name, serviceInstallID, value)
+		},
+		func(ctx context.Context, envVarID int) error {
+			response, err := b.httpClient.R().
+				SetContext(ctx).
+				SetBody(valueRequest{Value: value}).
+				Patch("/v6/device_service_environment_variable(" + strconv.Itoa(envVarID) + ")")
+			if err != nil {
+				return fmt.Errorf("failed performing request to update device(%s) service(%s) env var(%s): %w", balenaDeviceUUID, serviceName, name, err)
+			}
+
+			if response.IsError() {
+				return fmt.Errorf("error updating device(%s) service(%s) env var(%s): %w", balenaDeviceUUID, serviceName, name, newAPIError(response))
+			}
+
+			return nil
+		})
+}
+
 func (b *cloudClient) ForceApply(
 	ctx context.Context,
 	balenaDeviceUUID string,
 ) error {
+	b.logger.InfoContext(ctx, "supervisor action", "action", "update", "device", balenaDeviceUUID, "force", true)
+
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(map[string]interface{}{
@@ -590,7 +1729,7 @@ func (b *cloudClient) ForceApply(
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error force updating device(%s): request returned error: %s", balenaDeviceUUID, response.Body())
+		return fmt.Errorf("error force updating device(%s): request returned error: %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	return nil
@@ -624,6 +1763,7 @@ func (b *cloudClient) RestartAllServices(
 		body["data"] = map[string]interface{}{"force": true}
 	}
 
+	b.logger.InfoContext(ctx, "supervisor action", "action", "restart", "device", balenaDeviceUUID, "force", force)
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(body).
@@ -633,7 +1773,7 @@ func (b *cloudClient) RestartAllServices(
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error restarting all services on device(%s): %s", balenaDeviceUUID, response.Body())
+		return fmt.Errorf("error restarting all services on device(%s): %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	return nil
@@ -644,15 +1784,14 @@ func (b *cloudClient) DeleteDeviceServiceEnvVar(
 ) error {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
-		Delete("/v6/device_service_environment_variable(" + strconv.Itoa(envVarID) + ")?$filter=service_install/any(si:si/device%20eq%20" +
-			strconv.Itoa(balenaDeviceID) + ")")
+		Delete("/v6/device_service_environment_variable(" + strconv.Itoa(envVarID) + ")?" + NewQuery().Filter(deviceServiceInstallFilter(balenaDeviceID)).String())
 
 	if err != nil {
 		return fmt.Errorf("failed performing request to delete device(%d) service env var(%d): %w", balenaDeviceID, envVarID, err)
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error deleting device(%d) service env var(%d): %s", balenaDeviceID, envVarID, response.Body())
+		return fmt.Errorf("error deleting device(%d) service env var(%d): %w", balenaDeviceID, envVarID, newAPIError(response))
 	}
 
 	return nil
@@ -679,12 +1818,175 @@ func (b *cloudClient) SetDeviceName(ctx context.Context, balenaDeviceUUID, name
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error setting device(%s) name(%s): %s", balenaDeviceUUID, name, response.Body())
+		return fmt.Errorf("error setting device(%s) name(%s): %w", balenaDeviceUUID, name, newAPIError(response))
+	}
+
+	return nil
+}
+
+func (b *cloudClient) GetDeviceTags(
+	ctx context.Context,
+	balenaDeviceUUID string,
+) ([]DeviceTag, error) {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return nil, ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	return b.getDeviceTags(ctx, NewQuery().Filter(Eq("device", id)))
+}
+
+func (b *cloudClient) getDeviceTags(ctx context.Context, query *Query) ([]DeviceTag, error) {
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[DeviceTag]{}).
+		Get("/v6/device_tag?" + query.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request to get device tags: %w", err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting device tags: %w", newAPIError(response))
+	}
+
+	return response.Result().(*Response[DeviceTag]).D, nil
+}
+
+// SetDeviceTag creates the tag or, if the device already has a tag with that
+// key, updates its value.
+func (b *cloudClient) SetDeviceTag(
+	ctx context.Context,
+	balenaDeviceUUID, key, value string,
+) error {
+	if !utf8.ValidString(value) {
+		return ErrInvalidValue
+	}
+
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	_, err = upsert(ctx, value,
+		func(ctx context.Context) (*DeviceTag, error) {
+			return b.getDeviceTag(ctx, id, key)
+		},
+		func(ctx context.Context) error {
+			return b.createDeviceTag(ctx, balenaDeviceUUID, id, key, value)
+		},
+		func(ctx context.Context, tagID int) error {
+			response, err := b.httpClient.R().
+				SetContext(ctx).
+				SetBody(valueRequest{Value: value}).
+				Patch("/v6/device_tag(" + strconv.Itoa(tagID) + ")")
+			if err != nil {
+				return fmt.Errorf("failed performing request to update device(%s) tag(%s): %w", balenaDeviceUUID, key, err)
+			}
+
+			if response.IsError() {
+				return fmt.Errorf("error updating device(%s) tag(%s): %w", balenaDeviceUUID, key, newAPIError(response))
+			}
+
+			return nil
+		})
+
+	return err
+}
+
+func (b *cloudClient) getDeviceTag(ctx context.Context, deviceID int, key string) (*DeviceTag, error) {
+	tags, err := b.getDeviceTags(ctx, NewQuery().Filter(And(Eq("device", deviceID), Eq("tag_key", key))))
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%d) tag(%s): %w", deviceID, key, err)
+	}
+
+	if len(tags) == 0 {
+		return nil, ErrResourceNotFound
+	}
+
+	return &tags[0], nil
+}
+
+func (b *cloudClient) createDeviceTag(
+	ctx context.Context,
+	balenaDeviceUUID string,
+	deviceID int,
+	key, value string,
+) error {
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(b.getDeviceTag(ctx, deviceID, key))
+	})
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"device":  deviceID,
+			"tag_key": key,
+			"value":   value,
+		}).
+		Post("/v6/device_tag")
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed performing request to create device(%s) tag(%s): %w", balenaDeviceUUID, key, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error creating device(%s) tag(%s): %w", balenaDeviceUUID, key, newAPIError(response))
+	}
+
+	return nil
+}
+
+// DeleteDeviceTag removes the tag with the given key. Deleting a tag the
+// device does not have is not an error.
+func (b *cloudClient) DeleteDeviceTag(
+	ctx context.Context,
+	balenaDeviceUUID, key string,
+) error {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete("/v6/device_tag?" + NewQuery().Filter(And(Eq("device", id), Eq("tag_key", key))).String())
+	if err != nil {
+		return fmt.Errorf("failed performing request to delete device(%s) tag(%s): %w", balenaDeviceUUID, key, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error deleting device(%s) tag(%s): %w", balenaDeviceUUID, key, newAPIError(response))
 	}
 
 	return nil
 }
 
+// ListDevicesByTag returns the devices of a fleet whose tag key has the given
+// value. An empty value matches every device carrying the key.
+func (b *cloudClient) ListDevicesByTag(
+	ctx context.Context,
+	fleetName, key, value string,
+) ([]Device, error) {
+	return b.ListFleetDevices(ctx, fleetName, ListDevicesOptions{
+		TagKey:   key,
+		TagValue: value,
+	})
+}
+
 type HeaderSetter interface {
 	SetHeader(key, value string)
 }
@@ -693,7 +1995,7 @@ func (b *cloudClient) DownloadOS(
 	ctx context.Context, writer io.Writer, fleet string,
 	deviceType DeviceType, version string, headerSetter HeaderSetter,
 ) (string, error) {
-	flt, err := b.GetFleet(ctx, fleet)
+	fleetID, err := b.getFleetID(ctx, fleet)
 	if err != nil {
 		return "", err
 	}
@@ -702,7 +2004,7 @@ func (b *cloudClient) DownloadOS(
 		SetContext(ctx).
 		SetQueryParams(map[string]string{
 			"deviceType":      string(deviceType),
-			"appId":           fmt.Sprintf("%d", flt.ID),
+			"appId":           fmt.Sprintf("%d", fleetID),
 			"fileType":        ".zip",
 			"version":         version,
 			"network":         "ethernet",
@@ -716,7 +2018,7 @@ func (b *cloudClient) DownloadOS(
 	defer response.RawResponse.Body.Close()
 
 	if response.IsError() {
-		return "", fmt.Errorf("error downloading os: %s", response.Body())
+		return "", fmt.Errorf("error downloading os: %w", newAPIError(response))
 	}
 
 	var filename string
@@ -782,7 +2084,7 @@ func (b *cloudClient) MoveDeviceToFleet(
 		return ErrInvalidBalenaDeviceUUID
 	}
 
-	fleet, err := b.GetFleet(ctx, fleetName)
+	fleetID, err := b.getFleetID(ctx, fleetName)
 	if err != nil {
 		return err
 	}
@@ -795,7 +2097,7 @@ func (b *cloudClient) MoveDeviceToFleet(
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(map[string]interface{}{
-			"belongs_to__application": fleet.ID,
+			"belongs_to__application": fleetID,
 		}).
 		Patch("/v6/device(" + strconv.Itoa(deviceID) + ")")
 	if err != nil {
@@ -803,9 +2105,11 @@ func (b *cloudClient) MoveDeviceToFleet(
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error trying to move device(%s) to fleet(%s): %s", balenaDeviceUUID, fleetName, response.Body())
+		return fmt.Errorf("error trying to move device(%s) to fleet(%s): %w", balenaDeviceUUID, fleetName, newAPIError(response))
 	}
 
+	b.resolver.invalidate(deviceKey(balenaDeviceUUID))
+
 	return nil
 }
 
@@ -833,7 +2137,7 @@ func (b *cloudClient) EnablePublicDeviceURL(
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error trying to enable public device url(%s): %s", balenaDeviceUUID, response.Body())
+		return fmt.Errorf("error trying to enable public device url(%s): %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	return nil
@@ -857,7 +2161,7 @@ func (b *cloudClient) GetFleetReleases(
 	ctx context.Context,
 	name string,
 ) ([]Release, error) {
-	fleet, err := b.GetFleet(ctx, name)
+	fleetID, err := b.getFleetID(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -865,13 +2169,13 @@ func (b *cloudClient) GetFleetReleases(
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Response[Release]{}).
-		Get("/v6/release?$filter=belongs_to__application%20eq%20" + strconv.Itoa(fleet.ID) + "&" + OrderByCreatedAtQuerySelector + "&" + FleetReleasesQuerySelect)
+		Get("/v6/release?" + fleetReleasesQuery(fleetID).String())
 	if err != nil {
-		return nil, fmt.Errorf("failed performing request to get fleet %s(%d) releases: %w", name, fleet.ID, err)
+		return nil, fmt.Errorf("failed performing request to get fleet %s(%d) releases: %w", name, fleetID, err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting fleet %s(%d) releases: %s", name, fleet.ID, response.Body())
+		return nil, fmt.Errorf("error getting fleet %s(%d) releases: %w", name, fleetID, newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Response[Release])
@@ -881,6 +2185,31 @@ func (b *cloudClient) GetFleetReleases(
 	return balenaResult.D, nil
 }
 
+// IterFleetReleases is the paginated form of GetFleetReleases. It yields
+// nothing, rather than ErrResourceNotFound, for a fleet without releases.
+func (b *cloudClient) IterFleetReleases(
+	ctx context.Context,
+	name string,
+) iter.Seq2[Release, error] {
+	return func(yield func(Release, error) bool) {
+		fleetID, err := b.getFleetID(ctx, name)
+		if err != nil {
+			yield(Release{}, err)
+			return
+		}
+
+		// created_at alone is not unique, so tie-break on id to keep pages
+		// from overlapping.
+		query := fleetReleasesQuery(fleetID).OrderBy("id", Desc)
+
+		for release, err := range paginate[Release](ctx, b.httpClient, "/v6/release", query, b.pageSize) {
+			if !yield(release, err) {
+				return
+			}
+		}
+	}
+}
+
 func (b *cloudClient) PinDeviceToRelease(
 	ctx context.Context,
 	balenaDeviceUUID string,
@@ -904,7 +2233,7 @@ func (b *cloudClient) PinDeviceToRelease(
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error trying to pin device(%s) to release(%d): %s", balenaDeviceUUID, releaseID, response.Body())
+		return fmt.Errorf("error trying to pin device(%s) to release(%d): %w", balenaDeviceUUID, releaseID, newAPIError(response))
 	}
 
 	return nil
@@ -938,6 +2267,7 @@ func (b *cloudClient) Purge(
 		body["data"] = map[string]interface{}{"force": true}
 	}
 
+	b.logger.InfoContext(ctx, "supervisor action", "action", "purge", "device", balenaDeviceUUID, "force", force)
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetBody(body).
@@ -947,7 +2277,7 @@ func (b *cloudClient) Purge(
 	}
 
 	if response.IsError() {
-		return fmt.Errorf("error purging device(%s): %s", balenaDeviceUUID, response.Body())
+		return fmt.Errorf("error purging device(%s): %w", balenaDeviceUUID, newAPIError(response))
 	}
 
 	return nil
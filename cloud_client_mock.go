@@ -3,6 +3,7 @@ package gobalena
 import (
 	"context"
 	"io"
+	"iter"
 )
 
 var ()
@@ -68,6 +69,11 @@ func (m *mockCloudClient) GetDeviceID(ctx context.Context, balenaDeviceUUID stri
 	return 1, nil
 }
 
+// ResolveDevice implements CloudClient.
+func (m *mockCloudClient) ResolveDevice(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
+
 // GetDeviceServiceEnvVars implements CloudClient.
 func (m *mockCloudClient) GetDeviceServiceEnvVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceServiceEnvVar, error) {
 	return []DeviceServiceEnvVar{}, nil
@@ -156,4 +162,149 @@ func (m *mockCloudClient) RestartAllServices(ctx context.Context, balenaDeviceUU
 // Purge implements CloudClient.
 func (m *mockCloudClient) Purge(ctx context.Context, balenaDeviceUUID string, force bool) error {
 	return nil
-}
\ No newline at end of file
+}
+
+// IterDevicesDetails implements CloudClient.
+func (m *mockCloudClient) IterDevicesDetails(ctx context.Context, balenaDeviceUUIDs []string) iter.Seq2[Device, error] {
+	return func(yield func(Device, error) bool) {}
+}
+
+// IterDeviceEnvVars implements CloudClient.
+func (m *mockCloudClient) IterDeviceEnvVars(ctx context.Context, balenaDeviceUUID string) iter.Seq2[DeviceEnvVar, error] {
+	return func(yield func(DeviceEnvVar, error) bool) {}
+}
+
+// IterFleetEnvVars implements CloudClient.
+func (m *mockCloudClient) IterFleetEnvVars(ctx context.Context, name string) iter.Seq2[FleetEnvVar, error] {
+	return func(yield func(FleetEnvVar, error) bool) {}
+}
+
+// IterFleetReleases implements CloudClient.
+func (m *mockCloudClient) IterFleetReleases(ctx context.Context, name string) iter.Seq2[Release, error] {
+	return func(yield func(Release, error) bool) {}
+}
+
+// FindDevicesByNetwork implements CloudClient.
+func (m *mockCloudClient) FindDevicesByNetwork(ctx context.Context, fleetName, query string) ([]Device, error) {
+	return []Device{}, nil
+}
+
+// ListFleetDevices implements CloudClient.
+func (m *mockCloudClient) ListFleetDevices(ctx context.Context, fleetName string, opts ListDevicesOptions) ([]Device, error) {
+	return []Device{}, nil
+}
+
+// GetDeviceTags implements CloudClient.
+func (m *mockCloudClient) GetDeviceTags(ctx context.Context, balenaDeviceUUID string) ([]DeviceTag, error) {
+	return []DeviceTag{}, nil
+}
+
+// SetDeviceTag implements CloudClient.
+func (m *mockCloudClient) SetDeviceTag(ctx context.Context, balenaDeviceUUID string, key string, value string) error {
+	return nil
+}
+
+// DeleteDeviceTag implements CloudClient.
+func (m *mockCloudClient) DeleteDeviceTag(ctx context.Context, balenaDeviceUUID string, key string) error {
+	return nil
+}
+
+// ListDevicesByTag implements CloudClient.
+func (m *mockCloudClient) ListDevicesByTag(ctx context.Context, fleetName string, key string, value string) ([]Device, error) {
+	return []Device{}, nil
+}
+
+// CreateFleetEnvVar implements CloudClient.
+func (m *mockCloudClient) CreateFleetEnvVar(ctx context.Context, fleetName string, name string, value string) error {
+	return nil
+}
+
+// UpdateFleetEnvVar implements CloudClient.
+func (m *mockCloudClient) UpdateFleetEnvVar(ctx context.Context, fleetName string, name string, value string) error {
+	return nil
+}
+
+// UpsertFleetEnvVar implements CloudClient.
+func (m *mockCloudClient) UpsertFleetEnvVar(ctx context.Context, fleetName string, name string, value string) (bool, error) {
+	return false, nil
+}
+
+// DeleteFleetEnvVar implements CloudClient.
+func (m *mockCloudClient) DeleteFleetEnvVar(ctx context.Context, fleetName string, name string) error {
+	return nil
+}
+
+// CreateServiceEnvVar implements CloudClient.
+func (m *mockCloudClient) CreateServiceEnvVar(ctx context.Context, fleetName string, serviceName string, name string, value string) error {
+	return nil
+}
+
+// UpdateServiceEnvVar implements CloudClient.
+func (m *mockCloudClient) UpdateServiceEnvVar(ctx context.Context, fleetName string, serviceName string, name string, value string) error {
+	return nil
+}
+
+// UpsertServiceEnvVar implements CloudClient.
+func (m *mockCloudClient) UpsertServiceEnvVar(ctx context.Context, fleetName string, serviceName string, name string, value string) (bool, error) {
+	return false, nil
+}
+
+// DeleteServiceEnvVar implements CloudClient.
+func (m *mockCloudClient) DeleteServiceEnvVar(ctx context.Context, fleetName string, serviceName string, name string) error {
+	return nil
+}
+
+// SetDeviceEnvVar implements CloudClient.
+func (m *mockCloudClient) SetDeviceEnvVar(ctx context.Context, balenaDeviceUUID string, name string, value string) (bool, error) {
+	return false, nil
+}
+
+// SetDeviceServiceEnvVar implements CloudClient.
+func (m *mockCloudClient) SetDeviceServiceEnvVar(ctx context.Context, balenaDeviceUUID string, serviceName string, name string, value string) (bool, error) {
+	return false, nil
+}
+
+// GetEffectiveEnvVars implements CloudClient.
+func (m *mockCloudClient) GetEffectiveEnvVars(ctx context.Context, balenaDeviceUUID string) (map[string][]GenericEnvVar, error) {
+	return map[string][]GenericEnvVar{}, nil
+}
+
+// GetDeviceConfigVars implements CloudClient.
+func (m *mockCloudClient) GetDeviceConfigVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceConfigVar, error) {
+	return []DeviceConfigVar{}, nil
+}
+
+// GetDeviceConfigVar implements CloudClient.
+func (m *mockCloudClient) GetDeviceConfigVar(ctx context.Context, balenaDeviceUUID string, name string) (*DeviceConfigVar, error) {
+	return &DeviceConfigVar{}, nil
+}
+
+// SetDeviceConfigVar implements CloudClient.
+func (m *mockCloudClient) SetDeviceConfigVar(ctx context.Context, balenaDeviceUUID string, name string, value string) (bool, error) {
+	return false, nil
+}
+
+// DeleteDeviceConfigVar implements CloudClient.
+func (m *mockCloudClient) DeleteDeviceConfigVar(ctx context.Context, balenaDeviceUUID string, name string) error {
+	return nil
+}
+
+// GetFleetConfigVars implements CloudClient.
+func (m *mockCloudClient) GetFleetConfigVars(ctx context.Context, fleetName string) ([]FleetConfigVar, error) {
+	return []FleetConfigVar{}, nil
+}
+
+// GetFleetConfigVar implements CloudClient.
+func (m *mockCloudClient) GetFleetConfigVar(ctx context.Context, fleetName string, name string) (*FleetConfigVar, error) {
+	return &FleetConfigVar{}, nil
+}
+
+// SetFleetConfigVar implements CloudClient.
+func (m *mockCloudClient) SetFleetConfigVar(ctx context.Context, fleetName string, name string, value string) (bool, error) {
+	return false, nil
+}
+
+// DeleteFleetConfigVar implements CloudClient.
+func (m *mockCloudClient) DeleteFleetConfigVar(ctx context.Context, fleetName string, name string) error {
+	return nil
+}
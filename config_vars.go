@@ -0,0 +1,421 @@
+package gobalena
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Config variables hold the BALENA_SUPERVISOR_* and BALENA_HOST_* settings.
+// They live in their own device_config_variable and
+// application_config_variable resources, separate from environment variables.
+
+type configVarRow interface {
+	DeviceConfigVar | FleetConfigVar
+	row() (id int, value string)
+}
+
+func (v DeviceConfigVar) row() (int, string) { return v.ID, v.Value }
+func (v FleetConfigVar) row() (int, string)  { return v.ID, v.Value }
+
+// configVarTable addresses the config variables of a single device or fleet.
+type configVarTable struct {
+	resource string
+	owner    string
+	ownerID  int
+	// label identifies the owner in error messages, e.g. "device(<uuid>)".
+	label string
+}
+
+func (b *cloudClient) deviceConfigVars(ctx context.Context, balenaDeviceUUID string) (configVarTable, error) {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return configVarTable{}, ErrInvalidBalenaDeviceUUID
+	}
+
+	id, err := b.GetDeviceID(ctx, balenaDeviceUUID)
+	if err != nil {
+		return configVarTable{}, fmt.Errorf("failed getting device(%s) ID: %w", balenaDeviceUUID, err)
+	}
+
+	return configVarTable{
+		resource: "/v6/device_config_variable",
+		owner:    "device",
+		ownerID:  id,
+		label:    "device(" + balenaDeviceUUID + ")",
+	}, nil
+}
+
+func (b *cloudClient) fleetConfigVars(ctx context.Context, fleetName string) (configVarTable, error) {
+	fleetID, err := b.getFleetID(ctx, fleetName)
+	if err != nil {
+		return configVarTable{}, fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+	}
+
+	return configVarTable{
+		resource: "/v6/application_config_variable",
+		owner:    "application",
+		ownerID:  fleetID,
+		label:    "fleet(" + fleetName + ")",
+	}, nil
+}
+
+// listConfigVars returns every config variable of the table, or only the one
+// called name when name is not empty.
+func listConfigVars[T configVarRow](
+	ctx context.Context,
+	b *cloudClient,
+	t configVarTable,
+	name string,
+) ([]T, error) {
+	filter := Eq(t.owner, t.ownerID)
+	if name != "" {
+		filter = And(filter, Eq("name", name))
+	}
+
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetResult(Response[T]{}).
+		Get(t.resource + "?" + NewQuery().Filter(filter).String())
+	if err != nil {
+		return nil, fmt.Errorf("failed performing request for getting %s config vars: %w", t.label, err)
+	}
+
+	if response.IsError() {
+		return nil, fmt.Errorf("error getting %s config vars: %w", t.label, newAPIError(response))
+	}
+
+	return response.Result().(*Response[T]).D, nil
+}
+
+func getConfigVar[T configVarRow](
+	ctx context.Context,
+	b *cloudClient,
+	t configVarTable,
+	name string,
+) (*T, error) {
+	configVars, err := listConfigVars[T](ctx, b, t, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(configVars) == 0 {
+		return nil, ErrConfigVarNotFound
+	}
+
+	return &configVars[0], nil
+}
+
+func setConfigVar[T configVarRow](
+	ctx context.Context,
+	b *cloudClient,
+	t configVarTable,
+	name, value string,
+) (bool, error) {
+	b.observeEnvVar(name, value)
+
+	return upsert(ctx, value,
+		func(ctx context.Context) (*T, error) {
+			return getConfigVar[T](ctx, b, t, name)
+		},
+		func(ctx context.Context) error {
+			return createConfigVar[T](ctx, b, t, name, value)
+		},
+		func(ctx context.Context, id int) error {
+			response, err := b.httpClient.R().
+				SetContext(ctx).
+				SetBody(valueRequest{Value: value}).
+				Patch(t.resource + "(" + strconv.Itoa(id) + ")")
+			if err != nil {
+				return fmt.Errorf("failed performing request to update %s config var(%s): %w", t.label, name, err)
+			}
+
+			if response.IsError() {
+				return fmt.Errorf("error updating %s config var(%s): %w", t.label, name, newAPIError(response))
+			}
+
+			return nil
+		})
+}
+
+func createConfigVar[T configVarRow](
+	ctx context.Context,
+	b *cloudClient,
+	t configVarTable,
+	name, value string,
+) error {
+	ctx = WithExistenceCheck(ctx, func(ctx context.Context) (bool, error) {
+		return found(getConfigVar[T](ctx, b, t, name))
+	})
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			t.owner: t.ownerID,
+			"name":  name,
+			"value": value,
+		}).
+		Post(t.resource)
+	if CreatedAnyway(ctx) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed performing request to create %s config var(%s): %w", t.label, name, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error creating %s config var(%s): %w", t.label, name, newAPIError(response))
+	}
+
+	return nil
+}
+
+func deleteConfigVar[T configVarRow](
+	ctx context.Context,
+	b *cloudClient,
+	t configVarTable,
+	name string,
+) error {
+	configVar, err := getConfigVar[T](ctx, b, t, name)
+	if err != nil {
+		return fmt.Errorf("failed getting %s config var(%s): %w", t.label, name, err)
+	}
+
+	id, _ := (*configVar).row()
+	response, err := b.httpClient.R().
+		SetContext(ctx).
+		Delete(t.resource + "(" + strconv.Itoa(id) + ")")
+	if err != nil {
+		return fmt.Errorf("failed performing request to delete %s config var(%s): %w", t.label, name, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("error deleting %s config var(%s): %w", t.label, name, newAPIError(response))
+	}
+
+	return nil
+}
+
+func (b *cloudClient) GetDeviceConfigVars(
+	ctx context.Context,
+	balenaDeviceUUID string,
+) ([]DeviceConfigVar, error) {
+	t, err := b.deviceConfigVars(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return listConfigVars[DeviceConfigVar](ctx, b, t, "")
+}
+
+// GetDeviceConfigVar returns ErrConfigVarNotFound if the variable is not set
+// on the device itself, even when the fleet sets it.
+func (b *cloudClient) GetDeviceConfigVar(
+	ctx context.Context,
+	balenaDeviceUUID, name string,
+) (*DeviceConfigVar, error) {
+	t, err := b.deviceConfigVars(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return getConfigVar[DeviceConfigVar](ctx, b, t, name)
+}
+
+// SetDeviceConfigVar creates or updates a device config variable, reporting
+// whether anything changed.
+func (b *cloudClient) SetDeviceConfigVar(
+	ctx context.Context,
+	balenaDeviceUUID, name, value string,
+) (bool, error) {
+	if !utf8.ValidString(value) {
+		return false, ErrInvalidValue
+	}
+
+	t, err := b.deviceConfigVars(ctx, balenaDeviceUUID)
+	if err != nil {
+		return false, err
+	}
+
+	return setConfigVar[DeviceConfigVar](ctx, b, t, name, value)
+}
+
+func (b *cloudClient) DeleteDeviceConfigVar(
+	ctx context.Context,
+	balenaDeviceUUID, name string,
+) error {
+	t, err := b.deviceConfigVars(ctx, balenaDeviceUUID)
+	if err != nil {
+		return err
+	}
+
+	return deleteConfigVar[DeviceConfigVar](ctx, b, t, name)
+}
+
+func (b *cloudClient) GetFleetConfigVars(
+	ctx context.Context,
+	fleetName string,
+) ([]FleetConfigVar, error) {
+	t, err := b.fleetConfigVars(ctx, fleetName)
+	if err != nil {
+		return nil, err
+	}
+
+	return listConfigVars[FleetConfigVar](ctx, b, t, "")
+}
+
+func (b *cloudClient) GetFleetConfigVar(
+	ctx context.Context,
+	fleetName, name string,
+) (*FleetConfigVar, error) {
+	t, err := b.fleetConfigVars(ctx, fleetName)
+	if err != nil {
+		return nil, err
+	}
+
+	return getConfigVar[FleetConfigVar](ctx, b, t, name)
+}
+
+// SetFleetConfigVar creates or updates a fleet config variable, reporting
+// whether anything changed.
+func (b *cloudClient) SetFleetConfigVar(
+	ctx context.Context,
+	fleetName, name, value string,
+) (bool, error) {
+	if !utf8.ValidString(value) {
+		return false, ErrInvalidValue
+	}
+
+	t, err := b.fleetConfigVars(ctx, fleetName)
+	if err != nil {
+		return false, err
+	}
+
+	return setConfigVar[FleetConfigVar](ctx, b, t, name, value)
+}
+
+func (b *cloudClient) DeleteFleetConfigVar(
+	ctx context.Context,
+	fleetName, name string,
+) error {
+	t, err := b.fleetConfigVars(ctx, fleetName)
+	if err != nil {
+		return err
+	}
+
+	return deleteConfigVar[FleetConfigVar](ctx, b, t, name)
+}
+
+// SupervisorSetting is a typed view over a supervisor config variable, taking
+// care of the string encoding the supervisor expects:
+//
+//	changed, err := gobalena.SupervisorPollInterval.SetOnDevice(ctx, client, uuid, 10*time.Minute)
+//	locked, err := gobalena.SupervisorLockOverride.GetFromDevice(ctx, client, uuid)
+type SupervisorSetting[T any] struct {
+	Name   string
+	format func(T) string
+	parse  func(string) (T, error)
+}
+
+// See <https://docs.balena.io/learn/manage/configuration/>.
+var (
+	SupervisorPollInterval = SupervisorSetting[time.Duration]{
+		Name:   "BALENA_SUPERVISOR_POLL_INTERVAL",
+		format: formatMilliseconds,
+		parse:  parseMilliseconds,
+	}
+	SupervisorLocalMode = SupervisorSetting[bool]{
+		Name:   "BALENA_SUPERVISOR_LOCAL_MODE",
+		format: formatFlag,
+		parse:  parseFlag,
+	}
+	SupervisorPersistentLogging = SupervisorSetting[bool]{
+		Name:   "BALENA_SUPERVISOR_PERSISTENT_LOGGING",
+		format: formatFlag,
+		parse:  parseFlag,
+	}
+	SupervisorLockOverride = SupervisorSetting[bool]{
+		Name:   "BALENA_SUPERVISOR_OVERRIDE_LOCK",
+		format: formatFlag,
+		parse:  parseFlag,
+	}
+)
+
+// Value returns the config variable value encoding v.
+func (s SupervisorSetting[T]) Value(v T) string {
+	return s.format(v)
+}
+
+// Parse decodes a config variable value.
+func (s SupervisorSetting[T]) Parse(value string) (T, error) {
+	v, err := s.parse(value)
+	if err != nil {
+		return v, fmt.Errorf("invalid %s value(%s): %w", s.Name, value, err)
+	}
+
+	return v, nil
+}
+
+func (s SupervisorSetting[T]) SetOnDevice(ctx context.Context, client CloudClient, balenaDeviceUUID string, v T) (bool, error) {
+	return client.SetDeviceConfigVar(ctx, balenaDeviceUUID, s.Name, s.format(v))
+}
+
+func (s SupervisorSetting[T]) SetOnFleet(ctx context.Context, client CloudClient, fleetName string, v T) (bool, error) {
+	return client.SetFleetConfigVar(ctx, fleetName, s.Name, s.format(v))
+}
+
+// GetFromDevice returns the value set on the device itself, or
+// ErrConfigVarNotFound if the device does not override it.
+func (s SupervisorSetting[T]) GetFromDevice(ctx context.Context, client CloudClient, balenaDeviceUUID string) (T, error) {
+	configVar, err := client.GetDeviceConfigVar(ctx, balenaDeviceUUID, s.Name)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return s.Parse(configVar.Value)
+}
+
+func (s SupervisorSetting[T]) GetFromFleet(ctx context.Context, client CloudClient, fleetName string) (T, error) {
+	configVar, err := client.GetFleetConfigVar(ctx, fleetName, s.Name)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return s.Parse(configVar.Value)
+}
+
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+func parseMilliseconds(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func formatFlag(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
+
+// parseFlag accepts the spellings the supervisor itself treats as booleans.
+func parseFlag(s string) (bool, error) {
+	switch s {
+	case "1", "true", "on":
+		return true, nil
+	case "0", "false", "off", "":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("not a boolean: %q", s)
+}
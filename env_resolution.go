@@ -0,0 +1,164 @@
+package gobalena
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// GetEffectiveEnvVars returns, per service name, the environment each service
+// of the device ends up with. Levels are applied the way balena builds the
+// device target state, later ones overriding earlier ones:
+//
+//  1. fleet variables
+//  2. fleet service variables
+//  3. device variables
+//  4. device service variables
+//
+// Only services installed on the device are returned; variables of other
+// services are ignored. Variables within a service are sorted by name.
+func (b *cloudClient) GetEffectiveEnvVars(
+	ctx context.Context,
+	balenaDeviceUUID string,
+) (map[string][]GenericEnvVar, error) {
+	if !IsValidBalenaDeviceUUID(balenaDeviceUUID) {
+		return nil, ErrInvalidBalenaDeviceUUID
+	}
+
+	dev, err := b.GetDeviceDetails(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%s) details: %w", balenaDeviceUUID, err)
+	}
+
+	if len(dev.BelongsToApplication) == 0 {
+		return nil, fmt.Errorf("device(%s) has no belongs_to__application returned from API", balenaDeviceUUID)
+	}
+	fleetName := dev.BelongsToApplication[0].AppName
+
+	installs, err := b.GetDeviceServiceInstallIDs(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%s) service installs: %w", balenaDeviceUUID, err)
+	}
+
+	fleetVars, err := b.GetFleetEnvVars(ctx, fleetName)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting fleet(%s) env vars: %w", fleetName, err)
+	}
+
+	fleetServiceVars, err := b.GetServiceEnvVars(ctx, fleetName)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting fleet(%s) service env vars: %w", fleetName, err)
+	}
+
+	deviceVars, err := b.GetDeviceEnvVars(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%s) env vars: %w", balenaDeviceUUID, err)
+	}
+
+	deviceServiceVars, err := b.GetDeviceServiceEnvVars(ctx, balenaDeviceUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting device(%s) service env vars: %w", balenaDeviceUUID, err)
+	}
+
+	// Fleet service variables cover every service of the fleet, including
+	// those of releases the device does not run, so only the device's own
+	// service installs make up the result.
+	envs := newEnvResolution()
+	for _, install := range installs {
+		envs.addService(ServiceShort{ID: install.ServiceID, ServiceName: install.ServiceName})
+	}
+
+	for _, envVar := range fleetVars {
+		envs.setAll(envVar.ID, envVar.Name, envVar.Value, EnvVarSourceFleet)
+	}
+
+	for _, envVar := range fleetServiceVars {
+		for _, service := range envVar.Service {
+			envs.set(service.ServiceName, envVar.ID, envVar.Name, envVar.Value, EnvVarSourceFleetService)
+		}
+	}
+
+	for _, envVar := range deviceVars {
+		envs.setAll(envVar.ID, envVar.Name, envVar.Value, EnvVarSourceDevice)
+	}
+
+	for _, envVar := range deviceServiceVars {
+		for _, install := range envVar.ServiceInstall {
+			for _, service := range install.InstallsService {
+				envs.set(service.ServiceName, envVar.ID, envVar.Name, envVar.Value, EnvVarSourceDeviceService)
+			}
+		}
+	}
+
+	return envs.result(), nil
+}
+
+// envResolution accumulates the effective variables of every service. Levels
+// must be applied from lowest to highest precedence.
+type envResolution struct {
+	services map[string]ServiceShort
+	vars     map[string]map[string]*GenericEnvVar
+}
+
+func newEnvResolution() *envResolution {
+	return &envResolution{
+		services: map[string]ServiceShort{},
+		vars:     map[string]map[string]*GenericEnvVar{},
+	}
+}
+
+func (r *envResolution) addService(service ServiceShort) {
+	if existing, ok := r.services[service.ServiceName]; ok && existing.ID != 0 {
+		return
+	}
+
+	r.services[service.ServiceName] = service
+	if r.vars[service.ServiceName] == nil {
+		r.vars[service.ServiceName] = map[string]*GenericEnvVar{}
+	}
+}
+
+func (r *envResolution) setAll(id int, name, value string, source EnvVarSource) {
+	for serviceName := range r.services {
+		r.set(serviceName, id, name, value, source)
+	}
+}
+
+// set ignores services that were not added.
+func (r *envResolution) set(serviceName string, id int, name, value string, source EnvVarSource) {
+	serviceVars, ok := r.vars[serviceName]
+	if !ok {
+		return
+	}
+
+	envVar, ok := serviceVars[name]
+	if !ok {
+		envVar = &GenericEnvVar{Name: name, Service: r.services[serviceName]}
+		serviceVars[name] = envVar
+	}
+
+	envVar.ID = id
+	envVar.Value = value
+	envVar.Source = source
+	switch source {
+	case EnvVarSourceFleet, EnvVarSourceFleetService:
+		envVar.FleetValue = value
+	case EnvVarSourceDevice, EnvVarSourceDeviceService:
+		envVar.DeviceValue = value
+	}
+}
+
+func (r *envResolution) result() map[string][]GenericEnvVar {
+	result := make(map[string][]GenericEnvVar, len(r.vars))
+	for serviceName, serviceVars := range r.vars {
+		names := slices.Sorted(maps.Keys(serviceVars))
+		envVars := make([]GenericEnvVar, 0, len(names))
+		for _, name := range names {
+			envVars = append(envVars, *serviceVars[name])
+		}
+		result[serviceName] = envVars
+	}
+
+	return result
+}
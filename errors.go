@@ -1,11 +1,178 @@
 package gobalena
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
 
 var (
 	ErrInvalidBalenaDeviceUUID = errors.New("invalid balena device uuid")
-	ErrResourceNotFound  = errors.New("resource not found")
-	ErrExpectedOneResult = errors.New("expected one result")
-	ErrEnvVarNotFound    = errors.New("env var not found")
-	ErrInvalidReleaseID  = errors.New("invalid release ID: must be greater than 0")
+	ErrResourceNotFound        = errors.New("resource not found")
+	ErrExpectedOneResult       = errors.New("expected one result")
+	ErrEnvVarNotFound          = errors.New("env var not found")
+	ErrServiceNotFound         = errors.New("service not found")
+	ErrConfigVarNotFound       = errors.New("config var not found")
+	ErrInvalidReleaseID        = errors.New("invalid release ID: must be greater than 0")
+	ErrUnauthorized            = errors.New("unauthorized")
+	ErrConflict                = errors.New("conflict")
+	ErrRateLimited             = errors.New("rate limited")
+	ErrInvalidNetworkQuery     = errors.New("neither an IP nor a MAC address")
+	ErrInvalidValue            = errors.New("invalid value: must be valid UTF-8")
+)
+
+const (
+	// maxAPIErrorMessageLength bounds how much of an unparseable response
+	// body ends up in an APIError message.
+	maxAPIErrorMessageLength = 512
+	requestIDHeader          = "X-Request-Id"
 )
+
+// redactedQueryParams lists the query parameters whose values never make it
+// into an APIError.
+var redactedQueryParams = []string{"apikey", "api_key", "token"}
+
+// APIError is returned by CloudClient and LocalClient methods when balena
+// answers with a non-2XX status code. Use errors.Is with ErrResourceNotFound,
+// ErrUnauthorized, ErrConflict or ErrRateLimited to branch on the status, or
+// errors.As to inspect the details.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	if e.RequestID != "" {
+		msg += " (request id " + e.RequestID + ")"
+	}
+
+	return msg
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrResourceNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// AmbiguousDeviceError is returned by ResolveDevice when a reference matches
+// more than one device. It matches ErrExpectedOneResult with errors.Is.
+type AmbiguousDeviceError struct {
+	Ref string
+	// Matches holds the ID, UUID and name of the devices found, which are
+	// not necessarily all of them.
+	Matches []Device
+}
+
+func (e *AmbiguousDeviceError) Error() string {
+	matches := make([]string, len(e.Matches))
+	for i, device := range e.Matches {
+		matches[i] = fmt.Sprintf("%s (%s, id %d)", device.UUID, device.DeviceName, device.ID)
+	}
+
+	return fmt.Sprintf("device reference %q is ambiguous, it matches %s", e.Ref, strings.Join(matches, ", "))
+}
+
+func (e *AmbiguousDeviceError) Is(target error) bool {
+	return target == ErrExpectedOneResult
+}
+
+// DevicesNotFoundError is returned by GetDevicesDetails, along with the
+// devices it did find, when some of the requested devices do not exist. It
+// matches ErrResourceNotFound with errors.Is.
+type DevicesNotFoundError struct {
+	UUIDs []string
+}
+
+func (e *DevicesNotFoundError) Error() string {
+	return fmt.Sprintf("%d devices not found: %s", len(e.UUIDs), strings.Join(e.UUIDs, ", "))
+}
+
+func (e *DevicesNotFoundError) Is(target error) bool {
+	return target == ErrResourceNotFound
+}
+
+func newAPIError(response *resty.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: response.StatusCode(),
+		RequestID:  response.Header().Get(requestIDHeader),
+	}
+
+	if response.Request != nil {
+		apiErr.Method = response.Request.Method
+		apiErr.Path = requestPath(response.Request)
+	}
+
+	body := response.Body()
+	if body == nil && response.RawResponse != nil && response.RawResponse.Body != nil {
+		// Streaming requests skip response parsing, so the body is still
+		// unread.
+		body, _ = io.ReadAll(io.LimitReader(response.RawResponse.Body, maxAPIErrorMessageLength))
+	}
+	apiErr.Message = parseErrorMessage(body)
+
+	if response.Request != nil {
+		redactor := redactorFrom(response.Request.Context())
+		apiErr.Path = redactor.Redact(apiErr.Path)
+		apiErr.Message = redactor.Redact(apiErr.Message)
+	}
+
+	return apiErr
+}
+
+// parseErrorMessage extracts the human readable part of a balena error body.
+// The API answers either with plain text, a JSON string or a JSON object
+// carrying an "error" or "message" field, depending on the endpoint.
+func parseErrorMessage(body []byte) string {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" {
+		return ""
+	}
+
+	var str string
+	if err := json.Unmarshal([]byte(trimmed), &str); err == nil {
+		return str
+	}
+
+	var obj struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(trimmed), &obj); err == nil {
+		if obj.Message != "" {
+			return obj.Message
+		}
+
+		if obj.Error != "" {
+			return obj.Error
+		}
+	}
+
+	if len(trimmed) > maxAPIErrorMessageLength {
+		trimmed = trimmed[:maxAPIErrorMessageLength] + "..."
+	}
+
+	return trimmed
+}
@@ -5,6 +5,10 @@ go 1.23.12
 require (
 	github.com/go-resty/resty/v2 v2.16.0
 	github.com/google/uuid v1.6.0
+	go.opentelemetry.io/otel v1.34.0
+	go.opentelemetry.io/otel/metric v1.34.0
+	go.opentelemetry.io/otel/trace v1.34.0
+	golang.org/x/time v0.8.0
 )
 
 require golang.org/x/net v0.31.0 // indirect
@@ -4,8 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"strconv"
-	"strings"
+	"log/slog"
 )
 
 type LocalClient interface {
@@ -20,6 +19,23 @@ type LocalClient interface {
 	DeviceState(ctx context.Context) (*DeviceState, error)
 	Purge(ctx context.Context) error
 	StreamLogs(ctx context.Context, stream chan []byte) error
+	UpdateLock() *UpdateLock
+}
+
+type serviceRequest struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type forceRequest struct {
+	Force bool `json:"force"`
+}
+
+type journalLogsRequest struct {
+	Follow bool   `json:"follow"`
+	All    bool   `json:"all"`
+	Unit   string `json:"unit"`
+	Count  int    `json:"count"`
+	Format string `json:"format"`
 }
 
 type localClient struct {
@@ -29,201 +45,206 @@ type localClient struct {
 	appID         string
 
 	httpClient *SturdyClient
+	lock       *UpdateLock
+	logger     *slog.Logger
 }
 
-func NewLocalClient(apiKey, supervisorURL, supervisorKey, appID string) LocalClient {
-	return &localClient{
+func NewLocalClient(apiKey, supervisorURL, supervisorKey, appID string, opts ...Option) LocalClient {
+	o := newClientOptions(opts)
+
+	lockFile := BalenaLockFile
+	if o.lockFile != "" {
+		lockFile = o.lockFile
+	}
+
+	var client LocalClient = &localClient{
 		apiKey:        apiKey,
 		supervisorURL: supervisorURL,
 		supervisorKey: supervisorKey,
 		appID:         appID,
 
-		httpClient: NewSturdyHTTPClient().SetBaseURL(supervisorURL),
+		// The supervisor accepts its key as a bearer token, which keeps it
+		// out of URLs and thus out of errors and proxy logs.
+		httpClient: o.newHTTPClient(apiKey, supervisorKey).
+			SetBaseURL(supervisorURL).
+			SetHeader("Authorization", "Bearer "+supervisorKey),
+		lock:   NewUpdateLock(lockFile).SetLogger(o.logger),
+		logger: loggerOrNop(o.logger),
+	}
+
+	if o.tracerProvider != nil {
+		client = newTracedLocalClient(client, o.tracerProvider)
 	}
+
+	return client
+}
+
+// UpdateLock returns the lock the client lifts around supervisor operations.
+// Acquire it, or enter a critical section on it, to keep updates and service
+// restarts from interrupting the application.
+func (b *localClient) UpdateLock() *UpdateLock {
+	return b.lock
 }
 
 func (b *localClient) RestartService(ctx context.Context, serviceName string) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before restarting service: %w", err)
-	}
-	defer func() {
-		err = Lock(BalenaLockFile)
+	b.logger.InfoContext(ctx, "supervisor action", "action", "restart-service", "service", serviceName)
+
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetBody(serviceRequest{ServiceName: serviceName}).
+			Post("/v2/applications/" + b.appID + "/restart-service")
 		if err != nil {
-			fmt.Println("error creating lockfile after restarting service")
+			return fmt.Errorf("failed performing request to restart service: %w", err)
 		}
-	}()
-
-	var data = strings.NewReader(`{"serviceName": "` + serviceName + `"}`)
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(data).
-		Post("/v2/applications/" + b.appID + "/restart-service?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request to restart service: %w", err)
-	}
 
-	if response.IsError() {
-		return fmt.Errorf("error restarting service: %s", response.Body())
-	}
+		if response.IsError() {
+			return fmt.Errorf("error restarting service: %w", newAPIError(response))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (b *localClient) StopService(ctx context.Context, serviceName string) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before stopping service: %w", err)
-	}
-	defer func() {
-		err = Lock(BalenaLockFile)
+	b.logger.InfoContext(ctx, "supervisor action", "action", "stop-service", "service", serviceName)
+
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetBody(serviceRequest{ServiceName: serviceName}).
+			Post("/v2/applications/" + b.appID + "/stop-service")
 		if err != nil {
-			fmt.Println("error creating lockfile after stopping service")
+			return fmt.Errorf("failed performing request to stop service: %w", err)
 		}
-	}()
 
-	var data = strings.NewReader(`{"serviceName": "` + serviceName + `"}`)
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(data).
-		Post("/v2/applications/" + b.appID + "/stop-service?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request to stop service: %w", err)
-	}
-
-	if response.IsError() {
-		return fmt.Errorf("error stopping service: %s", response.Body())
-	}
+		if response.IsError() {
+			return fmt.Errorf("error stopping service: %w", newAPIError(response))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (b *localClient) StartService(ctx context.Context, serviceName string) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before starting service: %w", err)
-	}
-	defer func() {
-		err = Lock(BalenaLockFile)
+	b.logger.InfoContext(ctx, "supervisor action", "action", "start-service", "service", serviceName)
+
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetBody(serviceRequest{ServiceName: serviceName}).
+			Post("/v2/applications/" + b.appID + "/start-service")
 		if err != nil {
-			fmt.Println("error creating lockfile after starting service")
+			return fmt.Errorf("failed performing request to start service: %w", err)
 		}
-	}()
-
-	var data = strings.NewReader(`{"serviceName": "` + serviceName + `"}`)
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(data).
-		Post("/v2/applications/" + b.appID + "/start-service?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request to start service: %w", err)
-	}
 
-	if response.IsError() {
-		return fmt.Errorf("error starting service: %s", response.Body())
-	}
+		if response.IsError() {
+			return fmt.Errorf("error starting service: %w", newAPIError(response))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (b *localClient) ServicesStatus(ctx context.Context) (*Status, error) {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(Status{}).
-		Get("/v2/state/status?apikey=" + b.supervisorKey)
+		Get("/v2/state/status")
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request for services status: %w", err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting services status: %s", response.Body())
+		return nil, fmt.Errorf("error getting services status: %w", newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*Status)
 	return balenaResult, nil
 }
 
+// UpdateRelease keeps the lock lifted until the supervisor no longer reports
+// the update, for at most defaultApplyTimeout, since it applies updates in
+// the background. Callers that lifted the lock themselves, as applyUpdate
+// does, decide on their own how long it stays lifted.
 func (b *localClient) UpdateRelease(ctx context.Context, force bool) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before updating release: %w", err)
-	}
-	defer func() {
-		err = Lock(BalenaLockFile)
+	b.logger.InfoContext(ctx, "supervisor action", "action", "update", "force", force)
+
+	lifted := ctx.Value(updatesAllowedKey{}) == b.lock
+
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetBody(forceRequest{Force: force}).
+			Post("/v1/update")
 		if err != nil {
-			fmt.Println("error creating lockfile after updating release")
+			return fmt.Errorf("failed performing request for updating release: %w", err)
 		}
-	}()
 
-	var data = strings.NewReader(`{"force": "` + strconv.FormatBool(force) + `"}`)
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(data).
-		Post("/v1/update?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request for updating release: %w", err)
-	}
+		if response.IsError() {
+			return fmt.Errorf("error updating release: %w", newAPIError(response))
+		}
 
-	if response.IsError() {
-		return fmt.Errorf("error updating release: %s", response.Body())
-	}
+		if lifted {
+			return nil
+		}
 
-	return nil
+		waitForUpdate(ctx, b, defaultApplyTimeout)
+
+		return nil
+	})
 }
 
 func (b *localClient) RebootSystem(ctx context.Context, force bool) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before rebooting system: %w", err)
-	}
+	b.logger.InfoContext(ctx, "supervisor action", "action", "reboot", "force", force)
 
-	var data = strings.NewReader(`{"force": "` + strconv.FormatBool(force) + `"}`)
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		SetBody(data).
-		Post("/v1/reboot?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request for rebooting system: %w", err)
-	}
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			SetBody(forceRequest{Force: force}).
+			Post("/v1/reboot")
+		if err != nil {
+			return fmt.Errorf("failed performing request for rebooting system: %w", err)
+		}
 
-	if response.IsError() {
-		return fmt.Errorf("error rebooting system: %s", response.Body())
-	}
+		if response.IsError() {
+			return fmt.Errorf("error rebooting system: %w", newAPIError(response))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (b *localClient) ShutdownSystem(ctx context.Context) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before shutting down: %w", err)
-	}
+	b.logger.InfoContext(ctx, "supervisor action", "action", "shutdown")
 
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		Post("/v1/shutdown?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request for shutting system down: %w", err)
-	}
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			Post("/v1/shutdown")
+		if err != nil {
+			return fmt.Errorf("failed performing request for shutting system down: %w", err)
+		}
 
-	if response.IsError() {
-		return fmt.Errorf("error shutting system down: %s", response.Body())
-	}
+		if response.IsError() {
+			return fmt.Errorf("error shutting system down: %w", newAPIError(response))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (b *localClient) ServicesState(ctx context.Context) (*map[string]interface{}, error) {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(map[string]interface{}{}).
-		Get("/v2/applications/state?apikey=" + b.supervisorKey)
+		Get("/v2/applications/state")
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request to get services state: %w", err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting services state: %s", response.Body())
+		return nil, fmt.Errorf("error getting services state: %w", newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*map[string]interface{})
@@ -234,13 +255,13 @@ func (b *localClient) DeviceState(ctx context.Context) (*DeviceState, error) {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetResult(DeviceState{}).
-		Get("/v1/device?apikey=" + b.supervisorKey)
+		Get("/v1/device")
 	if err != nil {
 		return nil, fmt.Errorf("failed performing request to get device state: %w", err)
 	}
 
 	if response.IsError() {
-		return nil, fmt.Errorf("error getting device state: %s", response.Body())
+		return nil, fmt.Errorf("error getting device state: %w", newAPIError(response))
 	}
 
 	balenaResult := response.Result().(*DeviceState)
@@ -248,38 +269,43 @@ func (b *localClient) DeviceState(ctx context.Context) (*DeviceState, error) {
 }
 
 func (b *localClient) Purge(ctx context.Context) error {
-	err := Unlock(BalenaLockFile)
-	if err != nil {
-		return fmt.Errorf("error unlocking lockfile before shutting down: %w", err)
-	}
+	b.logger.InfoContext(ctx, "supervisor action", "action", "purge")
 
-	response, err := b.httpClient.R().
-		SetContext(ctx).
-		Post("/v2/applications/" + b.appID + "/purge?apikey=" + b.supervisorKey)
-	if err != nil {
-		return fmt.Errorf("failed performing request to purge: %w", err)
-	}
+	return b.lock.WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		response, err := b.httpClient.R().
+			SetContext(ctx).
+			Post("/v2/applications/" + b.appID + "/purge")
+		if err != nil {
+			return fmt.Errorf("failed performing request to purge: %w", err)
+		}
 
-	if response.IsError() {
-		return fmt.Errorf("error purging: %s", response.Body())
-	}
+		if response.IsError() {
+			return fmt.Errorf("error purging: %w", newAPIError(response))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (b *localClient) StreamLogs(ctx context.Context, stream chan []byte) error {
 	response, err := b.httpClient.R().
 		SetContext(ctx).
 		SetDoNotParseResponse(true).
-		SetBody(`{"follow":true,"all":true,"unit":"balena.service","count":40,"format":"json"}`).
-		Post("/v2/journal-logs?apikey=" + b.supervisorKey)
+		SetBody(journalLogsRequest{
+			Follow: true,
+			All:    true,
+			Unit:   "balena.service",
+			Count:  40,
+			Format: "json",
+		}).
+		Post("/v2/journal-logs")
 	if err != nil {
 		return fmt.Errorf("failed performing request to stream logs: %w", err)
 	}
 	defer response.RawResponse.Body.Close()
 
 	if response.IsError() {
-		return fmt.Errorf("error streaming logs: %s", response.Body())
+		return fmt.Errorf("error streaming logs: %w", newAPIError(response))
 	}
 
 	scanner := bufio.NewScanner(response.RawResponse.Body)
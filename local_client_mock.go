@@ -1,11 +1,19 @@
 package gobalena
 
-import "context"
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
 
-type mockLocalClient struct{}
+type mockLocalClient struct {
+	lock *UpdateLock
+}
 
 func NewMockLocalClient() LocalClient {
-	return &mockLocalClient{}
+	return &mockLocalClient{
+		lock: NewUpdateLock(filepath.Join(os.TempDir(), "gobalena-mock", "updates.lock")),
+	}
 }
 
 // DeviceState implements LocalClient.
@@ -62,3 +70,8 @@ func (m *mockLocalClient) StreamLogs(ctx context.Context, stream chan []byte) er
 func (m *mockLocalClient) UpdateRelease(ctx context.Context, force bool) error {
 	return nil
 }
+
+// UpdateLock implements LocalClient.
+func (m *mockLocalClient) UpdateLock() *UpdateLock {
+	return m.lock
+}
@@ -1,14 +1,31 @@
 package gobalena
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 )
 
 const (
 	BalenaLockFile = "/tmp/balena/updates.lock"
+
+	// lockPollInterval is how often Acquire retries while another process
+	// holds the lock.
+	lockPollInterval = 100 * time.Millisecond
+	// lockRestoreTimeout bounds how long WithUpdatesAllowed waits to take
+	// the lock back after the operation.
+	lockRestoreTimeout = 30 * time.Second
 )
 
+// Deprecated: Unlock removes the lock file regardless of who created it. Use
+// UpdateLock instead.
 func Unlock(file string) error {
 	if _, err := os.Stat(file); err == nil {
 		err := os.Remove(file)
@@ -20,6 +37,8 @@ func Unlock(file string) error {
 	return nil
 }
 
+// Deprecated: Lock only creates the lock file and provides no mutual
+// exclusion. Use UpdateLock instead.
 func Lock(file string) error {
 	dir := filepath.Dir(file)
 	err := os.MkdirAll(dir, os.ModePerm)
@@ -36,3 +55,485 @@ func Lock(file string) error {
 
 	return nil
 }
+
+// LockOwner describes the process holding an update lock, as recorded in the
+// lock file.
+type LockOwner struct {
+	PID        int       `json:"pid"`
+	Hostname   string    `json:"hostname"`
+	Holders    []string  `json:"holders"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// UpdateLock prevents the balena supervisor from applying updates while it is
+// held. The supervisor treats the existence of the lock file as "locked";
+// on top of that the file is flock'ed, so that several containers sharing
+// /tmp/balena exclude each other instead of deleting each other's lock.
+//
+// Within a process the lock is reference counted per holder: every Acquire
+// must be paired with a call to the returned release function, and the file
+// is removed once the last holder is gone.
+type UpdateLock struct {
+	path string
+
+	// sem serializes taking the file lock and lifting it for
+	// WithUpdatesAllowed; it is a channel so that waiting for it can be
+	// cancelled.
+	sem chan struct{}
+
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	file       *os.File
+	holders    map[string]int
+	critical   int
+	acquiredAt time.Time
+	// released is closed and replaced whenever a holder releases, waking up
+	// WithUpdatesAllowed while it waits for critical sections.
+	released chan struct{}
+}
+
+func NewUpdateLock(path string) *UpdateLock {
+	return &UpdateLock{
+		path:     path,
+		logger:   nopLogger,
+		sem:      make(chan struct{}, 1),
+		holders:  map[string]int{},
+		released: make(chan struct{}),
+	}
+}
+
+func (l *UpdateLock) Path() string {
+	return l.path
+}
+
+// SetLogger logs taking, releasing and lifting the lock file to logger at
+// info level. It must be called before the lock is used.
+func (l *UpdateLock) SetLogger(logger *slog.Logger) *UpdateLock {
+	l.logger = loggerOrNop(logger)
+	return l
+}
+
+// Acquire takes the lock on behalf of holder, waiting for other processes to
+// release it until ctx is done. Use context.WithTimeout to bound the wait.
+// Holders within the same process never wait for each other, but do wait for
+// a running WithUpdatesAllowed to finish, unless a critical section is open:
+// then the lock cannot be lifted before it ends anyway.
+//
+// The returned function releases this acquisition; calling it more than once
+// has no further effect.
+func (l *UpdateLock) Acquire(ctx context.Context, holder string) (func() error, error) {
+	return l.acquire(ctx, holder, false)
+}
+
+// EnterCriticalSection acquires the lock like Acquire, and additionally makes
+// WithUpdatesAllowed wait until the returned function has been called. Use it
+// around work that must not be interrupted by a service restart, such as an
+// open POS transaction.
+func (l *UpdateLock) EnterCriticalSection(ctx context.Context, holder string) (func() error, error) {
+	return l.acquire(ctx, holder, true)
+}
+
+func (l *UpdateLock) acquire(ctx context.Context, holder string, critical bool) (func() error, error) {
+	// While a critical section is open the file stays held and no lift can
+	// start, so holders nested in it join right away instead of queueing
+	// behind a pending WithUpdatesAllowed, which waits for that very section.
+	l.mu.Lock()
+	if l.file != nil && l.critical > 0 {
+		l.register(holder, critical)
+		l.mu.Unlock()
+
+		return l.releaser(holder, critical), nil
+	}
+	l.mu.Unlock()
+
+	if err := l.enter(ctx); err != nil {
+		return nil, fmt.Errorf("error acquiring update lock(%s) for %s: %w", l.path, holder, err)
+	}
+	defer l.leave()
+
+	// The holder is registered in the same critical section that finds the
+	// file held, so that a concurrent release of the last other holder cannot
+	// drop the file in between.
+	l.mu.Lock()
+	if l.file != nil {
+		l.register(holder, critical)
+		l.mu.Unlock()
+	} else {
+		l.mu.Unlock()
+
+		f, err := lockFile(ctx, l.path)
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring update lock(%s) for %s: %w", l.path, holder, err)
+		}
+
+		l.mu.Lock()
+		l.file = f
+		l.acquiredAt = time.Now()
+		l.register(holder, critical)
+		l.mu.Unlock()
+
+		l.logger.InfoContext(ctx, "update lock taken", "path", l.path, "holder", holder)
+	}
+
+	return l.releaser(holder, critical), nil
+}
+
+// releaser returns the function releasing one acquisition by holder.
+func (l *UpdateLock) releaser(holder string, critical bool) func() error {
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			err = l.release(holder, critical)
+		})
+
+		return err
+	}
+}
+
+// register counts an acquisition by holder. l.mu must be held.
+func (l *UpdateLock) register(holder string, critical bool) {
+	l.holders[holder]++
+	if critical {
+		l.critical++
+	}
+	l.writeOwner()
+}
+
+// release never waits for the semaphore: the file is only dropped here once
+// the last holder is gone, acquire registers a holder in the same critical
+// section that finds the file held, and its slow path only runs while no
+// file is held, so the two cannot race.
+func (l *UpdateLock) release(holder string, critical bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.holders[holder]--
+	if l.holders[holder] <= 0 {
+		delete(l.holders, holder)
+	}
+
+	if critical {
+		l.critical--
+	}
+
+	close(l.released)
+	l.released = make(chan struct{})
+
+	if len(l.holders) > 0 {
+		l.writeOwner()
+		return nil
+	}
+
+	f := l.file
+	l.file = nil
+	if f == nil {
+		return nil
+	}
+
+	if err := unlockFile(f, l.path); err != nil {
+		return fmt.Errorf("error releasing update lock(%s): %w", l.path, err)
+	}
+	l.logger.Info("update lock released", "path", l.path, "holder", holder)
+
+	return nil
+}
+
+type updatesAllowedKey struct{}
+
+// WithUpdatesAllowed lifts the lock for exactly the duration of fn, so that
+// supervisor operations such as restarting a service are not refused. It
+// first waits for open critical sections to end and keeps new acquisitions
+// waiting until fn returns, except those made while a critical section is
+// still open; then it puts back whatever lock existed before.
+//
+// Calling WithUpdatesAllowed again with the context passed to fn runs the
+// inner function directly, so operations can be composed.
+func (l *UpdateLock) WithUpdatesAllowed(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if ctx.Value(updatesAllowedKey{}) == l {
+		return fn(ctx)
+	}
+
+	if err := l.enter(ctx); err != nil {
+		return fmt.Errorf("error waiting for update lock(%s): %w", l.path, err)
+	}
+	defer l.leave()
+
+	if err := l.waitForCriticalSections(ctx); err != nil {
+		return fmt.Errorf("error waiting for update lock(%s) critical sections: %w", l.path, err)
+	}
+
+	restore, err := l.lift()
+	if err != nil {
+		return err
+	}
+	l.logger.InfoContext(ctx, "update lock lifted", "path", l.path)
+
+	defer func() {
+		// Restoring must happen even if the caller's context is done.
+		restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockRestoreTimeout)
+		defer cancel()
+
+		restoreErr := restore(restoreCtx)
+		if restoreErr == nil {
+			l.logger.InfoContext(ctx, "update lock restored", "path", l.path)
+		}
+		err = errors.Join(err, restoreErr)
+	}()
+
+	return fn(context.WithValue(ctx, updatesAllowedKey{}, l))
+}
+
+func (l *UpdateLock) waitForCriticalSections(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		critical, released := l.critical, l.released
+		l.mu.Unlock()
+
+		if critical == 0 {
+			return nil
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// lift drops the lock file and returns the function putting it back. Besides
+// our own lock it lifts a lock file nobody holds a flock on, as left behind
+// by the deprecated Lock function, matching what Unlock used to do. A lock
+// held by another process is left alone. l.sem must be held.
+func (l *UpdateLock) lift() (func(ctx context.Context) error, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f := l.file; f != nil {
+		l.file = nil
+		if err := unlockFile(f, l.path); err != nil {
+			return nil, fmt.Errorf("error lifting update lock(%s): %w", l.path, err)
+		}
+
+		return l.relock, nil
+	}
+
+	f, err := tryLockFile(l.path, false)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errLockHeld) {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error lifting update lock(%s): %w", l.path, err)
+	}
+
+	if err := unlockFile(f, l.path); err != nil {
+		return nil, fmt.Errorf("error lifting update lock(%s): %w", l.path, err)
+	}
+
+	return func(context.Context) error {
+		if err := Lock(l.path); err != nil {
+			return fmt.Errorf("error restoring update lock(%s): %w", l.path, err)
+		}
+
+		return nil
+	}, nil
+}
+
+// relock takes the file lock back if any holder is left. l.sem must be held.
+func (l *UpdateLock) relock(ctx context.Context) error {
+	l.mu.Lock()
+	holders := len(l.holders)
+	l.mu.Unlock()
+
+	if holders == 0 {
+		return nil
+	}
+
+	f, err := lockFile(ctx, l.path)
+	if err != nil {
+		return fmt.Errorf("error restoring update lock(%s): %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.file = f
+	l.acquiredAt = time.Now()
+	l.writeOwner()
+
+	return nil
+}
+
+// Held reports whether this process currently holds the lock.
+func (l *UpdateLock) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file != nil
+}
+
+// Holders returns how many times each holder in this process has acquired
+// the lock without releasing it.
+func (l *UpdateLock) Holders() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	holders := make(map[string]int, len(l.holders))
+	for holder, count := range l.holders {
+		holders[holder] = count
+	}
+
+	return holders
+}
+
+// Owner reads who holds the lock from the lock file, whichever process that
+// is. It returns nil without error when the lock is free. Lock files created
+// by other tools carry no owner information, in which case a zero LockOwner
+// is returned.
+func (l *UpdateLock) Owner() (*LockOwner, error) {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading update lock(%s): %w", l.path, err)
+	}
+
+	owner := &LockOwner{}
+	if len(data) == 0 {
+		return owner, nil
+	}
+
+	if err := json.Unmarshal(data, owner); err != nil {
+		return &LockOwner{}, nil
+	}
+
+	return owner, nil
+}
+
+func (l *UpdateLock) enter(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *UpdateLock) leave() {
+	<-l.sem
+}
+
+// writeOwner records the current holders in the lock file. It is best effort:
+// the owner information is diagnostic and must not fail an acquisition.
+// l.mu must be held.
+func (l *UpdateLock) writeOwner() {
+	if l.file == nil {
+		return
+	}
+
+	holders := make([]string, 0, len(l.holders))
+	for holder := range l.holders {
+		holders = append(holders, holder)
+	}
+	sort.Strings(holders)
+
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(LockOwner{
+		PID:        os.Getpid(),
+		Hostname:   hostname,
+		Holders:    holders,
+		AcquiredAt: l.acquiredAt,
+	})
+	if err != nil {
+		return
+	}
+
+	if err := l.file.Truncate(0); err != nil {
+		return
+	}
+
+	_, _ = l.file.WriteAt(data, 0)
+}
+
+// lockFile creates the lock file and takes an exclusive flock on it, polling
+// until it succeeds or ctx is done.
+func lockFile(ctx context.Context, path string) (*os.File, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		f, err := tryLockFile(path, true)
+		if err == nil {
+			return f, nil
+		}
+
+		if !errors.Is(err, errLockHeld) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryLockFile makes a single attempt at flock'ing the lock file, returning
+// errLockHeld if another process has it.
+func tryLockFile(path string, create bool) (*os.File, error) {
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+	}
+
+	for {
+		f, err := os.OpenFile(path, flags, 0o644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := flock(f); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+
+		// The previous owner may have removed the file between our open and
+		// flock, in which case we locked an orphaned inode.
+		current, err := os.Stat(path)
+		if err == nil {
+			var locked os.FileInfo
+			locked, err = f.Stat()
+			if err == nil && os.SameFile(current, locked) {
+				return f, nil
+			}
+		}
+
+		_ = funlock(f)
+		_ = f.Close()
+
+		// Only a replaced or, when creating, removed file is worth another
+		// attempt; any other error would just repeat.
+		if err != nil && (!create || !errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+	}
+}
+
+// unlockFile removes the lock file while still holding the flock, so that no
+// other process can lock the file we are about to delete, then releases it.
+func unlockFile(f *os.File, path string) error {
+	removeErr := os.Remove(path)
+	if errors.Is(removeErr, os.ErrNotExist) {
+		removeErr = nil
+	}
+
+	return errors.Join(removeErr, funlock(f), f.Close())
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package gobalena
+
+import (
+	"errors"
+	"os"
+)
+
+var errLockHeld = errors.New("update lock held by another process")
+
+// flock is unavailable on this platform; UpdateLock then only excludes
+// holders within the same process.
+func flock(f *os.File) error {
+	return nil
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
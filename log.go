@@ -0,0 +1,118 @@
+package gobalena
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// nopLogger is used until a logger is configured, so that callers never have
+// to check for nil.
+var nopLogger = slog.New(nopHandler{})
+
+type nopHandler struct{}
+
+func (nopHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (nopHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h nopHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h nopHandler) WithGroup(string) slog.Handler           { return h }
+
+func loggerOrNop(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return nopLogger
+	}
+
+	return logger
+}
+
+// SetSlogLogger logs every request attempt to logger at debug level, and
+// routes resty's own warnings and errors to it as well.
+func (c *SturdyClient) SetSlogLogger(logger *slog.Logger) *SturdyClient {
+	c.logger = loggerOrNop(logger)
+	c.setRestyLogger()
+	return c
+}
+
+// setRestyLogger routes resty's own output through the redactor. Without a
+// configured logger it goes to slog.Default, much like resty's default
+// logger writes to stderr.
+func (c *SturdyClient) setRestyLogger() {
+	logger := c.logger
+	if logger == nopLogger {
+		logger = slog.Default()
+	}
+
+	c.Client.SetLogger(restyLogger{logger: logger, redactor: c.redactor})
+}
+
+func (c *SturdyClient) Logger() *slog.Logger {
+	return c.logger
+}
+
+func (c *SturdyClient) logResponse(_ *resty.Client, response *resty.Response) error {
+	ctx := response.Request.Context()
+	if !c.logger.Enabled(ctx, slog.LevelDebug) {
+		return nil
+	}
+
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "balena request",
+		slog.String("method", response.Request.Method),
+		slog.String("path", requestPath(response.Request)),
+		slog.Int("status", response.StatusCode()),
+		slog.Duration("duration", response.Time()),
+		slog.Int("attempt", response.Request.Attempt),
+	)
+
+	return nil
+}
+
+// logRetry and logError cover the attempts that never got a response and
+// thus never reach logResponse. Failures of the last attempt are left to
+// logError, which resty calls once the retries are exhausted.
+func (c *SturdyClient) logRetry(response *resty.Response, err error) {
+	if err == nil || response == nil || response.Request.Attempt > c.RetryCount {
+		return
+	}
+
+	c.logAttemptError(response.Request, err)
+}
+
+func (c *SturdyClient) logError(r *resty.Request, err error) {
+	var responseErr *resty.ResponseError
+	if errors.As(err, &responseErr) {
+		if responseErr.Response.RawResponse != nil {
+			return
+		}
+		err = responseErr.Err
+	}
+
+	c.logAttemptError(r, err)
+}
+
+func (c *SturdyClient) logAttemptError(r *resty.Request, err error) {
+	ctx := r.Context()
+	if !c.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "balena request failed",
+		slog.String("method", r.Method),
+		slog.String("path", requestPath(r)),
+		slog.Duration("duration", time.Since(r.Time)),
+		slog.Int("attempt", r.Attempt),
+		slog.String("error", c.redactor.Redact(err.Error())),
+	)
+}
+
+// requestPath returns the redacted path and query of r, falling back to the
+// URL it was created with if it has not been sent yet.
+func requestPath(r *resty.Request) string {
+	if r.RawRequest != nil {
+		return redactQueryParams(r.RawRequest.URL.RequestURI())
+	}
+
+	return redactQueryParams(r.URL)
+}
@@ -54,7 +54,8 @@ type DeviceState struct {
 
 type serializableResponse interface {
 	Device | DeviceTag | Fleet | DeviceEnvVar | Release | FleetEnvVar |
-		ServiceEnvVar | DeviceID | DeviceServiceEnvVar | ServiceInstallResp
+		ServiceEnvVar | DeviceID | DeviceServiceEnvVar | ServiceInstallResp |
+		ServiceShort | DeviceConfigVar | FleetConfigVar
 }
 
 type Response[T serializableResponse] struct {
@@ -217,6 +218,24 @@ type FleetEnvVar struct {
 	Value string `json:"value"`
 }
 
+type DeviceConfigVar struct {
+	ID     int `json:"id"`
+	Device struct {
+		ID int `json:"__id"`
+	} `json:"device"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type FleetConfigVar struct {
+	ID          int `json:"id"`
+	Application struct {
+		ID int `json:"__id"`
+	} `json:"application"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 type ServiceShort struct {
 	ID          int    `json:"id"`
 	ServiceName string `json:"service_name"`
@@ -249,9 +268,25 @@ type DeviceServiceEnvVar struct {
 	Value string `json:"value"`
 }
 
+// EnvVarSource is the level an effective environment variable was set at.
+type EnvVarSource string
+
+const (
+	EnvVarSourceFleet         EnvVarSource = "fleet"
+	EnvVarSourceFleetService  EnvVarSource = "fleet_service"
+	EnvVarSourceDevice        EnvVarSource = "device"
+	EnvVarSourceDeviceService EnvVarSource = "device_service"
+)
+
+// GenericEnvVar is the value a variable resolves to inside one service of a
+// device. ID and Source identify the variable that won; FleetValue and
+// DeviceValue hold the winning value at fleet and device level, so an
+// override is visible at a glance.
 type GenericEnvVar struct {
 	ID          int          `json:"id"`
 	Name        string       `json:"name"`
+	Value       string       `json:"value"`
+	Source      EnvVarSource `json:"source"`
 	FleetValue  string       `json:"fleet_value"`
 	DeviceValue string       `json:"device_value"`
 	Service     ServiceShort `json:"service"`
@@ -0,0 +1,124 @@
+package gobalena
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// IPAddresses parses the space separated local addresses the device
+// reports. Entries that are not IP addresses are skipped.
+func (d *Device) IPAddresses() []net.IP {
+	return parseIPs(d.IPAddress)
+}
+
+// MACAddresses parses the space separated MAC addresses of the device's
+// network interfaces. Entries that are not MAC addresses are skipped.
+func (d *Device) MACAddresses() []net.HardwareAddr {
+	var addrs []net.HardwareAddr
+	for _, field := range strings.Fields(d.MacAddress) {
+		if addr, err := net.ParseMAC(field); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// PublicAddresses parses the addresses the device was last seen connecting
+// to balena from.
+func (d *Device) PublicAddresses() []net.IP {
+	return parseIPs(d.PublicAddress)
+}
+
+func parseIPs(s string) []net.IP {
+	var ips []net.IP
+	for _, field := range strings.Fields(s) {
+		if ip := net.ParseIP(field); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// networkMatcher narrows a FindDevicesByNetwork query down on the server and
+// then checks the parsed addresses, since a substring match on the raw
+// fields would find 10.0.0.12 when looking for 10.0.0.1.
+type networkMatcher struct {
+	filter Filter
+	match  func(*Device) bool
+}
+
+func newNetworkMatcher(query string) (networkMatcher, error) {
+	query = strings.TrimSpace(query)
+
+	if ip := net.ParseIP(query); ip != nil {
+		return networkMatcher{
+			filter: Or(Contains("ip_address", ip.String()), Contains("public_address", ip.String())),
+			match: func(d *Device) bool {
+				return slices.ContainsFunc(d.IPAddresses(), ip.Equal) ||
+					slices.ContainsFunc(d.PublicAddresses(), ip.Equal)
+			},
+		}, nil
+	}
+
+	if mac, err := net.ParseMAC(query); err == nil {
+		// Devices report MAC addresses in either case.
+		return networkMatcher{
+			filter: Or(
+				Contains("mac_address", strings.ToLower(mac.String())),
+				Contains("mac_address", strings.ToUpper(mac.String())),
+			),
+			match: func(d *Device) bool {
+				return slices.ContainsFunc(d.MACAddresses(), func(addr net.HardwareAddr) bool {
+					return bytes.Equal(addr, mac)
+				})
+			},
+		}, nil
+	}
+
+	return networkMatcher{}, fmt.Errorf("%w: %q", ErrInvalidNetworkQuery, query)
+}
+
+// FindDevicesByNetwork returns the devices of a fleet whose local IP, public
+// IP or MAC address is query. An empty fleetName searches every device the
+// API key has access to.
+func (b *cloudClient) FindDevicesByNetwork(
+	ctx context.Context,
+	fleetName, query string,
+) ([]Device, error) {
+	matcher, err := newNetworkMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+
+	q := deviceDetailsQuery().
+		Filter(matcher.filter).
+		OrderBy("id", Asc)
+
+	if fleetName != "" {
+		fleetID, err := b.getFleetID(ctx, fleetName)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting fleet(%s): %w", fleetName, err)
+		}
+
+		q.Filter(Eq("belongs_to__application", fleetID))
+	}
+
+	devices := make([]Device, 0)
+	for device, err := range paginate[Device](ctx, b.httpClient, "/v6/device", q, b.pageSize) {
+		if err != nil {
+			return nil, fmt.Errorf("error finding devices by network(%s): %w", query, err)
+		}
+
+		if matcher.match(&device) {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices, nil
+}
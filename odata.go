@@ -0,0 +1,294 @@
+package gobalena
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SortOrder string
+
+const (
+	Asc  SortOrder = "asc"
+	Desc SortOrder = "desc"
+)
+
+// Filter is an OData $filter expression. Build one with Eq, Ne, In, Any, And,
+// Or and Not; the zero value matches everything and is dropped from queries.
+type Filter struct {
+	expr string
+}
+
+func (f Filter) String() string {
+	return f.expr
+}
+
+func (f Filter) IsZero() bool {
+	return f.expr == ""
+}
+
+// RawFilter wraps an already formatted OData expression. Values are not
+// escaped, so prefer the typed constructors for anything user supplied.
+func RawFilter(expr string) Filter {
+	return Filter{expr: expr}
+}
+
+func Eq(field string, value any) Filter {
+	return Filter{expr: field + " eq " + formatLiteral(value)}
+}
+
+func Ne(field string, value any) Filter {
+	return Filter{expr: field + " ne " + formatLiteral(value)}
+}
+
+func In[T any](field string, values ...T) Filter {
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = formatLiteral(value)
+	}
+
+	return Filter{expr: field + " in (" + strings.Join(literals, ",") + ")"}
+}
+
+func Contains(field, substring string) Filter {
+	return Filter{expr: "contains(" + field + "," + quote(substring) + ")"}
+}
+
+func StartsWith(field, prefix string) Filter {
+	return Filter{expr: "startswith(" + field + "," + quote(prefix) + ")"}
+}
+
+// Any matches when at least one entity of the navigation property satisfies
+// f, e.g. Any("service_install", "si", Eq("si/device", 42)).
+func Any(navigation, alias string, f Filter) Filter {
+	return Filter{expr: navigation + "/any(" + alias + ":" + f.expr + ")"}
+}
+
+func And(filters ...Filter) Filter {
+	return join(" and ", filters)
+}
+
+func Or(filters ...Filter) Filter {
+	return join(" or ", filters)
+}
+
+func Not(f Filter) Filter {
+	if f.IsZero() {
+		return f
+	}
+
+	return Filter{expr: "not(" + f.expr + ")"}
+}
+
+func join(op string, filters []Filter) Filter {
+	parts := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if !f.IsZero() {
+			parts = append(parts, f.expr)
+		}
+	}
+
+	switch len(parts) {
+	case 0:
+		return Filter{}
+	case 1:
+		return Filter{expr: parts[0]}
+	}
+
+	for i, part := range parts {
+		parts[i] = "(" + part + ")"
+	}
+
+	return Filter{expr: strings.Join(parts, op)}
+}
+
+// formatLiteral renders a Go value as an OData literal. Strings are quoted
+// with embedded single quotes doubled, as required by the spec.
+func formatLiteral(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case time.Time:
+		return quote(v.UTC().Format(time.RFC3339Nano))
+	case fmt.Stringer:
+		return quote(v.String())
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.String:
+		return quote(rv.String())
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	}
+
+	return quote(fmt.Sprint(value))
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Query builds the query string of a balena /v6 resource request. Methods
+// mutate and return the receiver so calls can be chained:
+//
+//	q := NewQuery().
+//		Filter(Eq("belongs_to__application", fleetID)).
+//		Select("id", "uuid").
+//		Expand("device_tag", NewQuery().Select("tag_key", "value")).
+//		OrderBy("created_at", Desc).
+//		Top(50)
+//	client.R().Get("/v6/device?" + q.String())
+type Query struct {
+	filter  Filter
+	selects []string
+	expands []expansion
+	orderBy []string
+	top     int
+	skip    int
+}
+
+type expansion struct {
+	field string
+	query *Query
+}
+
+func NewQuery() *Query {
+	return &Query{top: -1, skip: -1}
+}
+
+// Filter sets the $filter expression. Calling it again ANDs the new filter
+// with the existing one.
+func (q *Query) Filter(f Filter) *Query {
+	q.filter = And(q.filter, f)
+	return q
+}
+
+func (q *Query) Select(fields ...string) *Query {
+	q.selects = append(q.selects, fields...)
+	return q
+}
+
+// Expand adds a navigation property to $expand. sub holds the nested options
+// and may be nil.
+func (q *Query) Expand(field string, sub *Query) *Query {
+	q.expands = append(q.expands, expansion{field: field, query: sub})
+	return q
+}
+
+// ExpandCount expands the number of related entities instead of the entities
+// themselves, optionally restricted by the filter in sub.
+func (q *Query) ExpandCount(field string, sub *Query) *Query {
+	return q.Expand(field+"/$count", sub)
+}
+
+func (q *Query) OrderBy(field string, order SortOrder) *Query {
+	q.orderBy = append(q.orderBy, field+" "+string(order))
+	return q
+}
+
+func (q *Query) Top(n int) *Query {
+	q.top = n
+	return q
+}
+
+func (q *Query) Skip(n int) *Query {
+	q.skip = n
+	return q
+}
+
+func (q *Query) Clone() *Query {
+	c := *q
+	c.selects = append([]string(nil), q.selects...)
+	c.orderBy = append([]string(nil), q.orderBy...)
+	c.expands = make([]expansion, len(q.expands))
+	for i, e := range q.expands {
+		c.expands[i] = expansion{field: e.field}
+		if e.query != nil {
+			c.expands[i].query = e.query.Clone()
+		}
+	}
+
+	return &c
+}
+
+// String returns the encoded query string, without the leading "?".
+func (q *Query) String() string {
+	options := q.options()
+	for i, option := range options {
+		key, value, _ := strings.Cut(option, "=")
+		options[i] = key + "=" + escapeQueryValue(value)
+	}
+
+	return strings.Join(options, "&")
+}
+
+func (q *Query) options() []string {
+	var options []string
+	if !q.filter.IsZero() {
+		options = append(options, "$filter="+q.filter.expr)
+	}
+
+	if len(q.selects) > 0 {
+		options = append(options, "$select="+strings.Join(q.selects, ","))
+	}
+
+	if len(q.expands) > 0 {
+		expands := make([]string, len(q.expands))
+		for i, e := range q.expands {
+			expands[i] = e.field
+			if e.query == nil {
+				continue
+			}
+
+			if nested := e.query.options(); len(nested) > 0 {
+				expands[i] += "(" + strings.Join(nested, ";") + ")"
+			}
+		}
+		options = append(options, "$expand="+strings.Join(expands, ","))
+	}
+
+	if len(q.orderBy) > 0 {
+		options = append(options, "$orderby="+strings.Join(q.orderBy, ","))
+	}
+
+	if q.top >= 0 {
+		options = append(options, "$top="+strconv.Itoa(q.top))
+	}
+
+	if q.skip >= 0 {
+		options = append(options, "$skip="+strconv.Itoa(q.skip))
+	}
+
+	return options
+}
+
+// escapeQueryValue percent-encodes everything that would break out of a query
+// parameter value, while leaving the OData punctuation readable.
+func escapeQueryValue(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte("-_.~'(),$;/:=*@!", c) >= 0:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0F])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,275 @@
+package gobalena
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a client built by NewCloudClient or NewLocalClient.
+// Options that only make sense for one of them, such as WithPageSize or
+// WithLockFile, are ignored by the other.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	httpClient       *http.Client
+	transport        http.RoundTripper
+	proxyURL         string
+	timeout          time.Duration
+	retryCount       *int
+	retryWaitTime    time.Duration
+	retryMaxWaitTime time.Duration
+	retryPolicy      *RetryPolicy
+	logger           *slog.Logger
+	userAgent        string
+	rateLimiter      *RateLimiter
+	onThrottle       func(ThrottleEvent)
+	lockFile         string
+	pageSize         int
+	resolverCache    *ResolverCache
+	tracerProvider   trace.TracerProvider
+	meterProvider    metric.MeterProvider
+	redactor         *Redactor
+	sensitiveEnvVars []string
+	debug            bool
+}
+
+func newClientOptions(opts []Option) *clientOptions {
+	o := &clientOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithHTTPClient sends requests through a copy of client instead of a fresh
+// one, e.g. to share its connection pool. Options such as WithTimeout apply
+// to the copy only.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *clientOptions) {
+		o.httpClient = client
+	}
+}
+
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+func WithProxy(proxyURL string) Option {
+	return func(o *clientOptions) {
+		o.proxyURL = proxyURL
+	}
+}
+
+// WithTimeout bounds every single attempt of a request; retries get a fresh
+// timeout each.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetryCount overrides APIRetryCount, APIRetryBackoff and
+// MaxAPIRetryBackoff. A count of 0 disables retries. A Retry-After header is
+// waited out in full, even beyond maxWaitTime.
+func WithRetryCount(count int, waitTime, maxWaitTime time.Duration) Option {
+	return func(o *clientOptions) {
+		o.retryCount = &count
+		o.retryWaitTime = waitTime
+		o.retryMaxWaitTime = maxWaitTime
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *clientOptions) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithLogger logs requests at debug level, and lock and supervisor actions
+// at info level, to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithRateLimiter makes requests wait for limiter, which may be shared with
+// other clients. onThrottle may be nil.
+func WithRateLimiter(limiter *RateLimiter, onThrottle func(ThrottleEvent)) Option {
+	return func(o *clientOptions) {
+		o.rateLimiter = limiter
+		o.onThrottle = onThrottle
+	}
+}
+
+// WithLockFile replaces BalenaLockFile as the update lock of a LocalClient.
+func WithLockFile(path string) Option {
+	return func(o *clientOptions) {
+		o.lockFile = path
+	}
+}
+
+// WithPageSize sets how many entities a CloudClient fetches per request when
+// iterating. Defaults to DefaultPageSize.
+func WithPageSize(size int) Option {
+	return func(o *clientOptions) {
+		o.pageSize = size
+	}
+}
+
+// WithResolverCache makes a CloudClient remember what device UUIDs, fleet
+// names and service names resolve to. Without it every method resolves them
+// afresh.
+func WithResolverCache(cache *ResolverCache) Option {
+	return func(o *clientOptions) {
+		o.resolverCache = cache
+	}
+}
+
+// WithTracerProvider records a span for every client method, with a child
+// span for each attempt of the requests it sends.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *clientOptions) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider records request, error and retry counts and request
+// latency.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *clientOptions) {
+		o.meterProvider = mp
+	}
+}
+
+// WithRedactor shares redactor between clients, so that secrets learned by
+// one are scrubbed by all. The client's own keys, DefaultSensitiveEnvVars and
+// those of WithSensitiveEnvVars are added to it.
+func WithRedactor(redactor *Redactor) Option {
+	return func(o *clientOptions) {
+		o.redactor = redactor
+	}
+}
+
+// WithSensitiveEnvVars adds to DefaultSensitiveEnvVars, see
+// Redactor.AddSensitiveEnvVars.
+func WithSensitiveEnvVars(names ...string) Option {
+	return func(o *clientOptions) {
+		o.sensitiveEnvVars = append(o.sensitiveEnvVars, names...)
+	}
+}
+
+// WithDebug logs every request and response, including a curl command to
+// reproduce it, at debug level. Secrets known to the redactor are scrubbed.
+func WithDebug() Option {
+	return func(o *clientOptions) {
+		o.debug = true
+	}
+}
+
+func (o *clientOptions) newHTTPClient(secrets ...string) *SturdyClient {
+	var c *SturdyClient
+	if o.httpClient != nil {
+		// resty sets the timeout, transport and cookie jar on the client it
+		// is given, so hand it a copy rather than the caller's.
+		httpClient := *o.httpClient
+		c = newSturdyClient(resty.NewWithClient(&httpClient))
+	} else {
+		c = NewSturdyHTTPClient()
+	}
+
+	redactor := o.redactor
+	if redactor == nil {
+		redactor = NewRedactor()
+	}
+	redactor.AddSecret(secrets...)
+	redactor.AddSensitiveEnvVars(DefaultSensitiveEnvVars...)
+	redactor.AddSensitiveEnvVars(o.sensitiveEnvVars...)
+	c.SetRedactor(redactor)
+
+	if o.transport != nil {
+		c.SetTransport(o.transport)
+	}
+
+	if o.proxyURL != "" {
+		// SetProxy changes the transport in place, and it may be shared
+		// with the caller through WithHTTPClient or WithTransport.
+		if transport, ok := c.GetClient().Transport.(*http.Transport); ok {
+			c.SetTransport(transport.Clone())
+		}
+		c.SetProxy(o.proxyURL)
+	}
+
+	if o.timeout > 0 {
+		c.SetTimeout(o.timeout)
+	}
+
+	if o.retryCount != nil {
+		c.SetRetryCount(*o.retryCount).
+			SetRetryWaitTime(o.retryWaitTime).
+			SetRetryMaxWaitTime(o.retryMaxWaitTime)
+	}
+
+	if o.retryPolicy != nil {
+		c.SetRetryPolicy(*o.retryPolicy)
+	}
+
+	if o.logger != nil {
+		c.SetSlogLogger(o.logger)
+	}
+
+	if o.debug {
+		c.SetDebug(true).
+			EnableGenerateCurlOnDebug()
+	}
+
+	if o.userAgent != "" {
+		c.SetHeader("User-Agent", o.userAgent)
+	}
+
+	if o.rateLimiter != nil {
+		c.SetRateLimiter(o.rateLimiter).
+			OnThrottle(o.onThrottle)
+	}
+
+	if o.tracerProvider != nil || o.meterProvider != nil {
+		c.SetTelemetry(o.tracerProvider, o.meterProvider)
+	}
+
+	return c
+}
+
+// restyLogger adapts a slog.Logger to resty's printf style logger. resty
+// logs URLs, headers and, in debug mode, curl commands, so everything goes
+// through the redactor.
+type restyLogger struct {
+	logger   *slog.Logger
+	redactor *Redactor
+}
+
+func (l restyLogger) Errorf(format string, v ...interface{}) {
+	l.logger.Error(l.redactor.Redact(fmt.Sprintf(format, v...)))
+}
+
+func (l restyLogger) Warnf(format string, v ...interface{}) {
+	l.logger.Warn(l.redactor.Redact(fmt.Sprintf(format, v...)))
+}
+
+func (l restyLogger) Debugf(format string, v ...interface{}) {
+	l.logger.Debug(l.redactor.Redact(fmt.Sprintf(format, v...)))
+}
@@ -0,0 +1,66 @@
+package gobalena
+
+import (
+	"context"
+	"fmt"
+	"iter"
+)
+
+// DefaultPageSize is the number of rows requested per page by the Iter*
+// methods of CloudClient.
+const DefaultPageSize = 100
+
+// paginate walks a /v6 collection with $top/$skip, fetching the next page only
+// once the caller has consumed the previous one. Iteration stops at the first
+// error, which is yielded together with the zero value of T.
+func paginate[T serializableResponse](
+	ctx context.Context,
+	httpClient *SturdyClient,
+	resource string,
+	query *Query,
+	pageSize int,
+) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		for skip := 0; ; skip += pageSize {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			response, err := httpClient.R().
+				SetContext(ctx).
+				SetResult(Response[T]{}).
+				Get(resource + "?" + query.Clone().Top(pageSize).Skip(skip).String())
+			if err != nil {
+				yield(zero, fmt.Errorf("failed performing request to list %s (skip %d): %w", resource, skip, err))
+				return
+			}
+
+			if response.IsError() {
+				yield(zero, fmt.Errorf("error listing %s (skip %d): %w", resource, skip, newAPIError(response)))
+				return
+			}
+
+			page := response.Result().(*Response[T]).D
+			for _, item := range page {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if len(page) < pageSize {
+				return
+			}
+		}
+	}
+}
+
+// failed returns an iterator that yields err once, for Iter* methods that
+// cannot start paging.
+func failed[T any](err error) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield(zero, err)
+	}
+}
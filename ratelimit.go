@@ -0,0 +1,110 @@
+package gobalena
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type ThrottleReason string
+
+const (
+	// ThrottleRateLimiter means the request waited for a token of the
+	// client side RateLimiter.
+	ThrottleRateLimiter ThrottleReason = "rate_limiter"
+	// ThrottleRetryAfter means balena answered 429 or 503 with a Retry-After
+	// header and the retry waits for it.
+	ThrottleRetryAfter ThrottleReason = "retry_after"
+)
+
+// ThrottleEvent describes a request that was slowed down, see
+// SturdyClient.OnThrottle.
+type ThrottleEvent struct {
+	Reason ThrottleReason
+	Method string
+	Path   string
+	Wait   time.Duration
+}
+
+// RateLimiter is a token bucket shared by every SturdyClient it is set on, so
+// that several CloudClients in one process stay under a common request rate.
+// A Retry-After received by any of them pauses all of them.
+type RateLimiter struct {
+	limiter *rate.Limiter
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewRateLimiter allows requestsPerSecond requests on average, with bursts of
+// up to burst requests.
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done, and returns how
+// long it waited.
+func (l *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+
+	l.mu.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.mu.Unlock()
+
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	err := l.limiter.Wait(ctx)
+	return time.Since(start), err
+}
+
+// Pause holds back all requests for d, unless a longer pause is already in
+// effect.
+func (l *RateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// parseRetryAfter reads the Retry-After header, which holds either a number
+// of seconds or an HTTP date. It returns 0 when the header is missing or
+// unparseable.
+func parseRetryAfter(header http.Header) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
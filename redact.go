@@ -0,0 +1,224 @@
+package gobalena
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const redacted = "REDACTED"
+
+// DefaultSensitiveEnvVars are the env var names every client treats as
+// sensitive, on top of those configured with WithSensitiveEnvVars.
+var DefaultSensitiveEnvVars = []string{"*PASSWORD*", "*SECRET*", "*TOKEN*", "*API_KEY*", "*PRIVATE_KEY*"}
+
+// minSecretLength keeps short values such as "1" or "true" from being
+// scrubbed out of every message when a sensitive variable is set to them.
+const minSecretLength = 4
+
+// Redactor scrubs secrets from error messages, log lines and debug output.
+// It knows the client's keys, and learns the values of sensitive env vars as
+// the client sends them.
+type Redactor struct {
+	mu        sync.RWMutex
+	secrets   []string
+	sensitive []string
+}
+
+// NewRedactor returns a Redactor scrubbing secrets. Empty secrets are ignored.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.AddSecret(secrets...)
+
+	return r
+}
+
+func (r *Redactor) AddSecret(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength || slices.Contains(r.secrets, secret) {
+			continue
+		}
+
+		r.secrets = append(r.secrets, secret)
+	}
+}
+
+// AddSensitiveEnvVars marks env var names whose values must never show up.
+// Names are matched case-insensitively and may use path.Match patterns, e.g.
+// "*_TOKEN".
+func (r *Redactor) AddSensitiveEnvVars(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if !slices.Contains(r.sensitive, name) {
+			r.sensitive = append(r.sensitive, name)
+		}
+	}
+}
+
+func (r *Redactor) IsSensitive(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name = strings.ToUpper(name)
+	for _, pattern := range r.sensitive {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ObserveEnvVar remembers value as a secret if name is sensitive. Clients
+// call it before sending a variable, so that the value is scrubbed from the
+// errors and logs of that very request.
+func (r *Redactor) ObserveEnvVar(name, value string) {
+	if r != nil && r.IsSensitive(name) {
+		r.AddSecret(value)
+	}
+}
+
+// observeBody learns the values of the sensitive variables listed in a JSON
+// response body, so that values read from balena are scrubbed as well.
+func (r *Redactor) observeBody(body []byte) {
+	if r == nil || !bytes.Contains(body, []byte(`"value"`)) {
+		return
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return
+	}
+
+	r.observeJSON(v)
+}
+
+func (r *Redactor) observeJSON(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		name, _ := v["name"].(string)
+		if value, ok := v["value"].(string); ok {
+			r.ObserveEnvVar(name, value)
+		}
+
+		for _, child := range v {
+			r.observeJSON(child)
+		}
+	case []any:
+		for _, child := range v {
+			r.observeJSON(child)
+		}
+	}
+}
+
+// with returns a copy of r that also scrubs secrets.
+func (r *Redactor) with(secrets ...string) *Redactor {
+	c := NewRedactor()
+	if r != nil {
+		r.mu.RLock()
+		c.secrets = slices.Clone(r.secrets)
+		c.sensitive = slices.Clone(r.sensitive)
+		r.mu.RUnlock()
+	}
+
+	c.AddSecret(secrets...)
+
+	return c
+}
+
+// Redact replaces every known secret in s, as well as the values of query
+// parameters such as apikey.
+func (r *Redactor) Redact(s string) string {
+	if r != nil {
+		r.mu.RLock()
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+		r.mu.RUnlock()
+	}
+
+	return redactQueryParams(s)
+}
+
+// queryParamPattern matches redactedQueryParams wherever they appear in a
+// message, e.g. inside the URL of a *url.Error.
+var queryParamPattern = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(redactedQueryParams, "|") + `)=)[^&#\s"']*`)
+
+func redactQueryParams(s string) string {
+	return queryParamPattern.ReplaceAllString(s, "${1}"+redacted)
+}
+
+type redactorKey struct{}
+
+type hiddenValuesKey struct{}
+
+// hideValues marks values sent without the name of their variable, such as
+// by UpdateDeviceEnvVar, which can therefore not be told apart from harmless
+// ones. They are scrubbed from the errors of the request and its debug dump
+// is skipped.
+func hideValues(ctx context.Context, values ...string) context.Context {
+	return context.WithValue(ctx, hiddenValuesKey{}, values)
+}
+
+func redactorFrom(ctx context.Context) *Redactor {
+	r, _ := ctx.Value(redactorKey{}).(*Redactor)
+	return r
+}
+
+// SetRedactor scrubs the secrets known to redactor from the errors and logs
+// of this client.
+func (c *SturdyClient) SetRedactor(redactor *Redactor) *SturdyClient {
+	c.redactor = redactor
+	c.setRestyLogger()
+	return c
+}
+
+func (c *SturdyClient) Redactor() *Redactor {
+	return c.redactor
+}
+
+// attachRedactor makes the redactor reachable from newAPIError.
+func (c *SturdyClient) attachRedactor(_ *resty.Client, r *resty.Request) error {
+	redactor := c.redactor
+	if hidden, _ := r.Context().Value(hiddenValuesKey{}).([]string); len(hidden) > 0 {
+		redactor = redactor.with(hidden...)
+		r.SetDebug(false)
+	}
+
+	if redactor == nil {
+		return nil
+	}
+
+	r.SetContext(context.WithValue(r.Context(), redactorKey{}, redactor))
+
+	return nil
+}
+
+// observeResponseLog runs right before resty dumps a response in debug
+// mode, which happens ahead of every OnAfterResponse hook.
+func (c *SturdyClient) observeResponseLog(rl *resty.ResponseLog) error {
+	c.redactor.observeBody([]byte(rl.Body))
+	return nil
+}
+
+// observeResponse learns from the responses that were not dumped.
+func (c *SturdyClient) observeResponse(_ *resty.Client, r *resty.Response) error {
+	if !r.Request.Debug {
+		c.redactor.observeBody(r.Body())
+	}
+
+	return nil
+}
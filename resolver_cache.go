@@ -0,0 +1,194 @@
+package gobalena
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+)
+
+type resolverKind string
+
+const (
+	resolveDevice  resolverKind = "device"
+	resolveFleet   resolverKind = "fleet"
+	resolveService resolverKind = "service"
+)
+
+var (
+	attrResolverKind   = attribute.Key("balena.resolver.kind")
+	attrResolverResult = attribute.Key("balena.resolver.result")
+)
+
+type resolverKey struct {
+	kind resolverKind
+	key  string
+}
+
+type resolverEntry struct {
+	id      int
+	expires time.Time
+}
+
+// ResolverCache remembers the IDs that device UUIDs, fleet names and service
+// names resolve to, saving a request in front of most CloudClient methods.
+// It may be shared between clients talking to the same balena instance.
+type ResolverCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[resolverKey]resolverEntry
+	nextSweep int
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// ResolverCacheStats counts the lookups answered from the cache and those
+// that had to ask balena.
+type ResolverCacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// NewResolverCache returns a cache whose entries expire after ttl. A device
+// moved or deleted through another client may thus resolve to a stale ID
+// for up to ttl.
+func NewResolverCache(ttl time.Duration) *ResolverCache {
+	return &ResolverCache{
+		ttl:     ttl,
+		entries: map[resolverKey]resolverEntry{},
+	}
+}
+
+func (c *ResolverCache) Stats() ResolverCacheStats {
+	return ResolverCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// Clear drops every entry, e.g. after fleets were renamed.
+func (c *ResolverCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
+func (c *ResolverCache) get(key resolverKey) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return 0, false
+	}
+
+	return entry.id, true
+}
+
+func (c *ResolverCache) set(key resolverKey, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// Entries are only dropped when looked up again, so sweep the expired
+	// ones whenever the map has doubled in size since the last sweep.
+	if len(c.entries) >= c.nextSweep {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = 2*len(c.entries) + 64
+	}
+
+	c.entries[key] = resolverEntry{id: id, expires: now.Add(c.ttl)}
+}
+
+func (c *ResolverCache) invalidate(key resolverKey) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// deviceKey lower-cases the UUID, which IsValidBalenaDeviceUUID accepts in
+// either case, so that every spelling shares one entry.
+func deviceKey(balenaDeviceUUID string) resolverKey {
+	return resolverKey{kind: resolveDevice, key: strings.ToLower(balenaDeviceUUID)}
+}
+
+func fleetKey(fleetName string) resolverKey {
+	return resolverKey{kind: resolveFleet, key: fleetName}
+}
+
+func serviceKey(fleetID int, serviceName string) resolverKey {
+	return resolverKey{kind: resolveService, key: strconv.Itoa(fleetID) + "/" + serviceName}
+}
+
+func newResolverLookups(mp metric.MeterProvider) metric.Int64Counter {
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+
+	counter, err := mp.Meter(instrumentationName).Int64Counter("balena.client.resolver.lookups",
+		metric.WithDescription("UUID and name to ID lookups, by kind and whether the cache answered them."))
+	if err != nil {
+		counter, _ = metricnoop.NewMeterProvider().Meter(instrumentationName).Int64Counter("")
+	}
+
+	return counter
+}
+
+// resolve answers key from the resolver cache, if any, or asks lookup and
+// remembers its answer. Failed lookups are not cached.
+func (b *cloudClient) resolve(
+	ctx context.Context,
+	key resolverKey,
+	lookup func() (int, error),
+) (int, error) {
+	if b.resolver == nil {
+		return lookup()
+	}
+
+	if id, ok := b.resolver.get(key); ok {
+		b.resolver.hits.Add(1)
+		b.resolverLookups.Add(ctx, 1, metric.WithAttributes(
+			attrResolverKind.String(string(key.kind)),
+			attrResolverResult.String("hit")))
+
+		return id, nil
+	}
+
+	b.resolver.misses.Add(1)
+	b.resolverLookups.Add(ctx, 1, metric.WithAttributes(
+		attrResolverKind.String(string(key.kind)),
+		attrResolverResult.String("miss")))
+
+	id, err := lookup()
+	if err != nil {
+		return 0, err
+	}
+
+	b.resolver.set(key, id)
+
+	return id, nil
+}
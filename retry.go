@@ -0,0 +1,115 @@
+package gobalena
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// RetryPolicy decides which failed requests SturdyClient sends again. Rate
+// limited requests were never processed and are always retried. Network
+// errors and 5XX responses are ambiguous: the request may or may not have
+// taken effect, so by default they are only retried for idempotent methods.
+type RetryPolicy struct {
+	// RetryNonIdempotent retries POST requests on ambiguous failures too,
+	// like SturdyClient used to.
+	RetryNonIdempotent bool
+}
+
+type retryOverrideKey struct{}
+
+type existenceCheckKey struct{}
+
+type existenceCheck struct {
+	check   ExistenceCheck
+	existed atomic.Bool
+}
+
+// ExistenceCheck reports whether the resource a failed POST was meant to
+// create exists after all.
+type ExistenceCheck func(ctx context.Context) (bool, error)
+
+// WithRetries marks requests made with ctx as safe to retry on ambiguous
+// failures, whatever their method.
+func WithRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryOverrideKey{}, true)
+}
+
+// WithoutRetries disables retries, including on 429, for requests made with
+// ctx.
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryOverrideKey{}, false)
+}
+
+// WithExistenceCheck makes an ambiguous POST failure retried only after check
+// confirmed that the resource was not created. If it was, retrying stops and
+// CreatedAnyway reports true for ctx.
+func WithExistenceCheck(ctx context.Context, check ExistenceCheck) context.Context {
+	return context.WithValue(ctx, existenceCheckKey{}, &existenceCheck{check: check})
+}
+
+// CreatedAnyway reports whether the existence check installed on ctx found
+// the resource of a failed POST.
+func CreatedAnyway(ctx context.Context) bool {
+	c, ok := ctx.Value(existenceCheckKey{}).(*existenceCheck)
+	return ok && c.existed.Load()
+}
+
+func (c *SturdyClient) SetRetryPolicy(policy RetryPolicy) *SturdyClient {
+	c.retryPolicy = policy
+	return c
+}
+
+func (c *SturdyClient) shouldRetry(r *resty.Response, err error) bool {
+	if r == nil || r.Request == nil {
+		// The request was refused before it was sent, e.g. by a cancelled
+		// rate limiter wait.
+		return false
+	}
+
+	ctx := r.Request.Context()
+	override, overridden := ctx.Value(retryOverrideKey{}).(bool)
+	if overridden && !override {
+		return false
+	}
+
+	if err == nil {
+		statusCode := r.StatusCode()
+		if statusCode == http.StatusTooManyRequests {
+			return true
+		}
+
+		// Do not retry on 4XX errors other than 429
+		if statusCode < 500 || statusCode > 599 {
+			return false
+		}
+	}
+
+	if overridden || c.retryPolicy.RetryNonIdempotent || isIdempotent(r.Request.Method) {
+		return true
+	}
+
+	check, ok := ctx.Value(existenceCheckKey{}).(*existenceCheck)
+	if !ok {
+		return false
+	}
+
+	exists, checkErr := check.check(ctx)
+	if checkErr != nil {
+		return false
+	}
+
+	check.existed.Store(exists)
+	return !exists
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
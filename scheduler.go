@@ -0,0 +1,227 @@
+package gobalena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	schedulerLockHolder           = "update-scheduler"
+	defaultSchedulerCheckInterval = time.Minute
+)
+
+// MaintenanceWindow opens updates on Weekday from Start until End, both wall
+// clock times given as the time since midnight in the schedule's time zone,
+// e.g. 3*time.Hour for 03:00 even on the day clocks change. An End before or
+// equal to Start spans midnight and closes on the following day.
+type MaintenanceWindow struct {
+	Weekday time.Weekday
+	Start   time.Duration
+	End     time.Duration
+}
+
+// UpdateSchedule describes when a device may apply updates. Blackouts are
+// dates on which no window opens, e.g. public holidays with peak trading;
+// only their year, month and day are considered, whatever their time zone,
+// so dates from time.Parse("2006-01-02", ...) work as they are.
+type UpdateSchedule struct {
+	Location  *time.Location
+	Windows   []MaintenanceWindow
+	Blackouts []time.Time
+}
+
+// InWindow reports whether t falls inside one of the schedule's maintenance
+// windows. A window spanning midnight belongs to the day it opens, so a
+// blackout on that day closes it past midnight as well.
+func (s UpdateSchedule) InWindow(t time.Time) bool {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if s.isBlackout(day) {
+			continue
+		}
+
+		for _, w := range s.Windows {
+			if w.Weekday != day.Weekday() {
+				continue
+			}
+
+			start := wallClock(day, w.Start)
+			end := wallClock(day, w.End)
+			if w.End <= w.Start {
+				end = wallClock(day.AddDate(0, 0, 1), w.End)
+			}
+
+			if !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// wallClock returns the time of day offset reads on a clock in day's time
+// zone. Adding offset to midnight instead would be an hour off on days the
+// clocks change.
+func wallClock(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		int(offset/time.Hour), int(offset%time.Hour/time.Minute), int(offset%time.Minute/time.Second),
+		int(offset%time.Second), day.Location())
+}
+
+func (s UpdateSchedule) isBlackout(day time.Time) bool {
+	for _, b := range s.Blackouts {
+		if b.Year() == day.Year() && b.Month() == day.Month() && b.Day() == day.Day() {
+			return true
+		}
+	}
+
+	return false
+}
+
+type UpdateSchedulerConfig struct {
+	Schedule UpdateSchedule
+	// CheckInterval is how often the schedule and the device state are
+	// checked. Defaults to one minute.
+	CheckInterval time.Duration
+	// AutoUpdate triggers UpdateRelease inside a window whenever the
+	// supervisor reports a pending update.
+	AutoUpdate bool
+	// ApplyTimeout bounds how long AutoUpdate keeps the lock lifted while
+	// waiting for the supervisor to apply the update. Defaults to 15
+	// minutes.
+	ApplyTimeout time.Duration
+	ForceUpdate  bool
+	// OnError, if set, is called with every error that Run retries on the
+	// next tick, such as the supervisor being unreachable while it restarts.
+	OnError func(err error)
+}
+
+// UpdateScheduler holds the client's update lock outside the maintenance
+// windows of its schedule and releases it inside them. Inside a window other
+// holders of the lock still keep updates out, unless AutoUpdate applies a
+// pending one: like UpdateWatcher, it then lifts every holder's lock until
+// the update is applied, waiting only for open critical sections.
+type UpdateScheduler struct {
+	client LocalClient
+	config UpdateSchedulerConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	release func() error
+}
+
+func NewUpdateScheduler(client LocalClient, config UpdateSchedulerConfig) *UpdateScheduler {
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = defaultSchedulerCheckInterval
+	}
+
+	if config.ApplyTimeout <= 0 {
+		config.ApplyTimeout = defaultApplyTimeout
+	}
+
+	return &UpdateScheduler{
+		client: client,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Run applies the schedule until ctx is done, then releases the lock if the
+// scheduler still holds it. Errors are passed to OnError and retried on the
+// next tick; outside a window the lock stays held regardless.
+func (s *UpdateScheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Check(ctx); err != nil && ctx.Err() == nil && s.config.OnError != nil {
+			s.config.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), s.unhold())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check applies the schedule once: it takes or drops the lock depending on
+// the current time and, with AutoUpdate, applies a pending update.
+func (s *UpdateScheduler) Check(ctx context.Context) error {
+	if !s.config.Schedule.InWindow(s.now()) {
+		return s.hold(ctx)
+	}
+
+	if err := s.unhold(); err != nil {
+		return err
+	}
+
+	if !s.config.AutoUpdate {
+		return nil
+	}
+
+	state, err := s.client.DeviceState(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking for pending update: %w", err)
+	}
+
+	if !state.UpdatePending {
+		return nil
+	}
+
+	if err := applyUpdate(ctx, s.client, s.config.ForceUpdate, s.config.ApplyTimeout); err != nil {
+		return fmt.Errorf("error applying pending update: %w", err)
+	}
+
+	return nil
+}
+
+// Holding reports whether the scheduler currently keeps updates out.
+func (s *UpdateScheduler) Holding() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.release != nil
+}
+
+func (s *UpdateScheduler) hold(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.release != nil {
+		return nil
+	}
+
+	release, err := s.client.UpdateLock().Acquire(ctx, schedulerLockHolder)
+	if err != nil {
+		return err
+	}
+	s.release = release
+
+	return nil
+}
+
+func (s *UpdateScheduler) unhold() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.release == nil {
+		return nil
+	}
+
+	release := s.release
+	s.release = nil
+
+	return release()
+}
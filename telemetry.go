@@ -0,0 +1,225 @@
+package gobalena
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentationName = "github.com/Round2POS/gobalena/v2"
+
+var (
+	attrDeviceUUID = attribute.Key("balena.device.uuid")
+	attrDeviceID   = attribute.Key("balena.device.id")
+	attrDeviceRef  = attribute.Key("balena.device.ref")
+	attrFleet      = attribute.Key("balena.fleet")
+	attrService    = attribute.Key("balena.service")
+	attrMethod     = attribute.Key("http.request.method")
+	attrPath       = attribute.Key("url.path")
+	attrStatusCode = attribute.Key("http.response.status_code")
+	attrAttempt    = attribute.Key("balena.attempt")
+)
+
+// telemetry holds the instruments SturdyClient records every attempt of a
+// request with.
+type telemetry struct {
+	tracer   trace.Tracer
+	requests metric.Int64Counter
+	errors   metric.Int64Counter
+	retries  metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry, error) {
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+
+	meter := mp.Meter(instrumentationName)
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+
+	var err, errs error
+	t.requests, err = meter.Int64Counter("balena.client.requests",
+		metric.WithDescription("Requests sent to balena, counting every attempt."))
+	errs = errors.Join(errs, err)
+
+	t.errors, err = meter.Int64Counter("balena.client.errors",
+		metric.WithDescription("Attempts that failed with a network error or a non-2XX status."))
+	errs = errors.Join(errs, err)
+
+	t.retries, err = meter.Int64Counter("balena.client.retries",
+		metric.WithDescription("Attempts that retried an earlier failed one."))
+	errs = errors.Join(errs, err)
+
+	t.duration, err = meter.Float64Histogram("balena.client.request.duration",
+		metric.WithDescription("Duration of a single attempt."),
+		metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+
+	if errs != nil {
+		return nil, errs
+	}
+
+	return t, nil
+}
+
+// SetTelemetry records a child span and metrics for every attempt of a
+// request. Either provider may be nil to only trace or only measure.
+func (c *SturdyClient) SetTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *SturdyClient {
+	t, err := newTelemetry(tp, mp)
+	if err != nil {
+		c.logger.Warn("failed setting up telemetry", "error", err)
+		return c
+	}
+
+	c.telemetry = t
+	return c
+}
+
+type attemptKey struct{}
+
+type attempt struct {
+	// parent is the context of the request, so that every attempt becomes
+	// a sibling rather than a child of the previous one.
+	parent context.Context
+	span   trace.Span
+	start  time.Time
+	ended  bool
+}
+
+func (c *SturdyClient) startAttempt(_ *resty.Client, r *resty.Request) error {
+	if c.telemetry == nil {
+		return nil
+	}
+
+	parent := r.Context()
+	if prev, ok := parent.Value(attemptKey{}).(*attempt); ok {
+		parent = prev.parent
+	}
+
+	attrs := []attribute.KeyValue{
+		attrMethod.String(r.Method),
+		attrPath.String(requestPath(r)),
+		attrAttempt.Int(r.Attempt),
+	}
+
+	if r.Attempt > 1 {
+		c.telemetry.retries.Add(parent, 1, metric.WithAttributes(attrs[0]))
+	}
+
+	ctx, span := c.telemetry.tracer.Start(parent, "balena "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	r.SetContext(context.WithValue(ctx, attemptKey{}, &attempt{
+		parent: parent,
+		span:   span,
+		start:  time.Now(),
+	}))
+
+	return nil
+}
+
+func (c *SturdyClient) traceResponse(_ *resty.Client, response *resty.Response) error {
+	c.endAttempt(response.Request, response.StatusCode(), nil)
+	return nil
+}
+
+// traceRetry and traceError end the attempts that never got a response, see
+// logRetry and logError.
+func (c *SturdyClient) traceRetry(response *resty.Response, err error) {
+	if err == nil || response == nil || response.Request.Attempt > c.RetryCount {
+		return
+	}
+
+	c.endAttempt(response.Request, 0, err)
+}
+
+func (c *SturdyClient) traceError(r *resty.Request, err error) {
+	var responseErr *resty.ResponseError
+	if errors.As(err, &responseErr) {
+		if responseErr.Response.RawResponse != nil {
+			return
+		}
+		err = responseErr.Err
+	}
+
+	c.endAttempt(r, 0, err)
+}
+
+func (c *SturdyClient) endAttempt(r *resty.Request, statusCode int, err error) {
+	if c.telemetry == nil {
+		return
+	}
+
+	a, ok := r.Context().Value(attemptKey{}).(*attempt)
+	if !ok || a.ended {
+		return
+	}
+	a.ended = true
+
+	// The path is left out of the metrics, it holds UUIDs and filters.
+	attrs := []attribute.KeyValue{attrMethod.String(r.Method)}
+	if statusCode != 0 {
+		attrs = append(attrs, attrStatusCode.Int(statusCode))
+	}
+	set := metric.WithAttributes(attrs...)
+
+	c.telemetry.requests.Add(a.parent, 1, set)
+	c.telemetry.duration.Record(a.parent, time.Since(a.start).Seconds(), set)
+
+	a.span.SetAttributes(attrs...)
+	switch {
+	case err != nil:
+		c.telemetry.errors.Add(a.parent, 1, set)
+		a.span.RecordError(err)
+		a.span.SetStatus(codes.Error, err.Error())
+	case statusCode >= 400:
+		c.telemetry.errors.Add(a.parent, 1, set)
+		a.span.SetStatus(codes.Error, "")
+	}
+
+	a.span.End()
+}
+
+// startSpan starts the span of a client method.
+func startSpan(
+	ctx context.Context,
+	tracer trace.Tracer,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, and the HTTP status of an APIError, on span and ends
+// it. It returns err so that callers can end the span in their return
+// statement.
+func endSpan(span trace.Span, err error) error {
+	defer span.End()
+
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		span.SetAttributes(attrStatusCode.Int(apiErr.StatusCode))
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	return err
+}
@@ -0,0 +1,156 @@
+// Hammers a single UpdateLock from many goroutines and checks that the lock
+// file exists whenever a holder thinks it holds the lock, is gone while
+// WithUpdatesAllowed runs, and that holders nested in a critical section
+// never wait for a pending lift:
+//
+// ```bash
+// go run -tags lockstress ./test/lockstress -workers 32 -cycles 100000
+// ```
+
+//go:build lockstress
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Round2POS/gobalena/v2"
+)
+
+var failures atomic.Int64
+
+func fail(format string, args ...any) {
+	if failures.Add(1) <= 10 {
+		log.Printf("FAIL "+format, args...)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// checkHeld verifies what a holder may rely on while it holds the lock.
+func checkHeld(lock *gobalena.UpdateLock, holder string) {
+	if !lock.Held() {
+		fail("%s holds the lock but Held() is false", holder)
+	}
+
+	if !fileExists(lock.Path()) {
+		fail("%s holds the lock but %s is missing", holder, lock.Path())
+	}
+}
+
+func main() {
+	workers := flag.Int("workers", 32, "number of concurrent holders")
+	cycles := flag.Int("cycles", 100000, "acquire/release cycles per worker")
+	flag.Parse()
+
+	ctx := context.Background()
+	dir, err := os.MkdirTemp("", "gobalena-lockstress")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock := gobalena.NewUpdateLock(filepath.Join(dir, "updates.lock"))
+
+	// Plain holders only: nothing lifts the lock, so it must be in place
+	// for the whole time any of them holds it.
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		holder := fmt.Sprintf("worker-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < *cycles; n++ {
+				release, err := lock.Acquire(ctx, holder)
+				if err != nil {
+					fail("%s: %v", holder, err)
+					return
+				}
+
+				checkHeld(lock, holder)
+
+				if err := release(); err != nil {
+					fail("%s release: %v", holder, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Critical sections racing WithUpdatesAllowed: a critical section must
+	// never see the lock lifted, and fn must never see it in place.
+	var lifts atomic.Int64
+	for i := 0; i < *workers; i++ {
+		holder := fmt.Sprintf("critical-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for n := 0; n < *cycles/10; n++ {
+				if n%8 == 0 {
+					err := lock.WithUpdatesAllowed(ctx, func(context.Context) error {
+						lifts.Add(1)
+						if fileExists(lock.Path()) {
+							fail("%s: lock file present while updates are allowed", holder)
+						}
+						return nil
+					})
+					if err != nil {
+						fail("%s WithUpdatesAllowed: %v", holder, err)
+					}
+					continue
+				}
+
+				release, err := lock.EnterCriticalSection(ctx, holder)
+				if err != nil {
+					fail("%s: %v", holder, err)
+					return
+				}
+
+				checkHeld(lock, holder)
+
+				// Nesting a plain holder in the critical section must not
+				// wait for a pending lift, which waits for this section.
+				nestedCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				nested, err := lock.Acquire(nestedCtx, holder+"-nested")
+				cancel()
+				if err != nil {
+					fail("%s nested: %v", holder, err)
+				} else {
+					checkHeld(lock, holder+"-nested")
+					if err := nested(); err != nil {
+						fail("%s nested release: %v", holder, err)
+					}
+				}
+
+				if err := release(); err != nil {
+					fail("%s release: %v", holder, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lock.Held() || len(lock.Holders()) > 0 || fileExists(lock.Path()) {
+		fail("lock still held after every holder released it: %v", lock.Holders())
+	}
+
+	if n := failures.Load(); n > 0 {
+		log.Fatalf("%d violations", n)
+	}
+
+	log.Printf("%d workers, %d cycles each, %d lifts: no violations", *workers, *cycles, lifts.Load())
+}
@@ -0,0 +1,279 @@
+// Checks that no API key, supervisor key or sensitive env var value escapes
+// into errors, log lines or debug output. It runs against local stub servers,
+// so it needs no balena credentials:
+//
+// ```bash
+// go run -tags redaction ./test/redaction
+// ```
+
+//go:build redaction
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Round2POS/gobalena/v2"
+)
+
+const (
+	apiKey        = "cloud-api-key-0123456789"
+	supervisorKey = "supervisor-key-9876543210"
+	deviceUUID    = "0123456789abcdef0123456789abcdef"
+	secretName    = "DB_PASSWORD"
+	secretValue   = "hunter2-correct-horse"
+	customName    = "STRIPE_WEBHOOK"
+	customValue   = "whsec-battery-staple"
+)
+
+var secrets = []string{apiKey, supervisorKey, secretValue, customValue}
+
+var failures int
+
+// check reports every secret found in what.
+func check(label, what string) {
+	for _, secret := range secrets {
+		if strings.Contains(what, secret) {
+			failures++
+			log.Printf("FAIL %s leaks %q:\n%s", label, secret, what)
+		}
+	}
+}
+
+// echoBody answers with a 400 that quotes the request body back, like some
+// balena validation errors do.
+func echoBody(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": "invalid body: " + string(body)})
+}
+
+// newSecret returns a distinct sensitive value for label, so that no call
+// benefits from a value the redactor learned from an earlier one.
+func newSecret(label string) string {
+	secret := "hunter2-" + strings.ToLower(label)
+	secrets = append(secrets, secret)
+
+	return secret
+}
+
+// existing makes the stub report every variable as already set, sending
+// the clients down their update paths. Each read returns a fresh secret, so
+// that reads are checked as well as writes.
+var (
+	existing bool
+	reads    int
+)
+
+func storedVariable() string {
+	reads++
+	value, _ := json.Marshal(newSecret(fmt.Sprintf("stored-%d", reads)))
+
+	return `{"d":[{"id":7,"name":"` + secretName + `","value":` + string(value) + `}]}`
+}
+
+func cloudServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method != http.MethodGet:
+			echoBody(w, r)
+		case strings.HasPrefix(r.URL.Path, "/v6/device("):
+			fmt.Fprint(w, `{"d":[{"id":42}]}`)
+		case r.URL.Path == "/v6/application":
+			fmt.Fprint(w, `{"d":[{"id":1,"app_name":"fleet"}]}`)
+		case r.URL.Path == "/v6/service":
+			fmt.Fprint(w, `{"d":[{"id":3,"service_name":"main"}]}`)
+		case r.URL.Path == "/v7/service_install":
+			fmt.Fprint(w, `{"d":[{"id":5,"installs__service":[{"id":3,"service_name":"main"}]}]}`)
+		case existing && strings.HasSuffix(r.URL.Path, "_variable"):
+			fmt.Fprint(w, storedVariable())
+		default:
+			fmt.Fprint(w, `{"d":[]}`)
+		}
+	}))
+}
+
+func supervisorServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("apikey") {
+			failures++
+			log.Printf("FAIL supervisor key sent in the URL: %s", r.URL)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+supervisorKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "bad authorization %q", r.Header.Get("Authorization"))
+			return
+		}
+
+		echoBody(w, r)
+	}))
+}
+
+func main() {
+	ctx := context.Background()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	opts := []gobalena.Option{
+		gobalena.WithLogger(logger),
+		gobalena.WithDebug(),
+		gobalena.WithSensitiveEnvVars(customName),
+		gobalena.WithRetryCount(1, time.Millisecond, time.Millisecond),
+	}
+
+	cloud := cloudServer()
+	defer cloud.Close()
+
+	cloudClient := gobalena.NewCloudClient(apiKey, cloud.URL, opts...)
+
+	_, err := cloudClient.SetDeviceEnvVar(ctx, deviceUUID, secretName, secretValue)
+	if err == nil {
+		log.Fatal("expected SetDeviceEnvVar to fail against the stub")
+	}
+	check("SetDeviceEnvVar error", err.Error())
+
+	err = cloudClient.CreateDeviceEnvVar(ctx, deviceUUID, customName, customValue)
+	if err == nil {
+		log.Fatal("expected CreateDeviceEnvVar to fail against the stub")
+	}
+	check("CreateDeviceEnvVar error", err.Error())
+
+	existing = true
+	reads := map[string]func() error{
+		"GetDeviceEnvVars": func() error {
+			_, err := cloudClient.GetDeviceEnvVars(ctx, deviceUUID)
+			return err
+		},
+		"GetFleetEnvVars": func() error {
+			_, err := cloudClient.GetFleetEnvVars(ctx, "fleet")
+			return err
+		},
+		"GetServiceEnvVars": func() error {
+			_, err := cloudClient.GetServiceEnvVars(ctx, "fleet")
+			return err
+		},
+		"GetDeviceServiceEnvVars": func() error {
+			_, err := cloudClient.GetDeviceServiceEnvVars(ctx, deviceUUID)
+			return err
+		},
+		"GetDeviceConfigVars": func() error {
+			_, err := cloudClient.GetDeviceConfigVars(ctx, deviceUUID)
+			return err
+		},
+		"GetFleetConfigVars": func() error {
+			_, err := cloudClient.GetFleetConfigVars(ctx, "fleet")
+			return err
+		},
+		"GetEffectiveEnvVars": func() error {
+			_, err := cloudClient.GetEffectiveEnvVars(ctx, deviceUUID)
+			return err
+		},
+	}
+	for name, read := range reads {
+		if err := read(); err != nil {
+			check(name+" error", err.Error())
+		}
+	}
+
+	updates := map[string]func(value string) error{
+		"UpdateDeviceEnvVar": func(value string) error {
+			return cloudClient.UpdateDeviceEnvVar(ctx, 42, 7, value)
+		},
+		"UpdateDeviceServiceEnvVar": func(value string) error {
+			return cloudClient.UpdateDeviceServiceEnvVar(ctx, 42, 7, value)
+		},
+		"SetDeviceEnvVar": func(value string) error {
+			_, err := cloudClient.SetDeviceEnvVar(ctx, deviceUUID, secretName, value)
+			return err
+		},
+		"UpdateFleetEnvVar": func(value string) error {
+			return cloudClient.UpdateFleetEnvVar(ctx, "fleet", secretName, value)
+		},
+		"UpsertFleetEnvVar": func(value string) error {
+			_, err := cloudClient.UpsertFleetEnvVar(ctx, "fleet", secretName, value)
+			return err
+		},
+		"UpdateServiceEnvVar": func(value string) error {
+			return cloudClient.UpdateServiceEnvVar(ctx, "fleet", "main", secretName, value)
+		},
+		"UpsertServiceEnvVar": func(value string) error {
+			_, err := cloudClient.UpsertServiceEnvVar(ctx, "fleet", "main", secretName, value)
+			return err
+		},
+		"SetDeviceServiceEnvVar": func(value string) error {
+			_, err := cloudClient.SetDeviceServiceEnvVar(ctx, deviceUUID, "main", secretName, value)
+			return err
+		},
+		"SetDeviceConfigVar": func(value string) error {
+			_, err := cloudClient.SetDeviceConfigVar(ctx, deviceUUID, secretName, value)
+			return err
+		},
+		"SetFleetConfigVar": func(value string) error {
+			_, err := cloudClient.SetFleetConfigVar(ctx, "fleet", secretName, value)
+			return err
+		},
+	}
+	for name, update := range updates {
+		err := update(newSecret(name))
+		if err == nil {
+			log.Fatalf("expected %s to fail against the stub", name)
+		}
+		check(name+" error", err.Error())
+	}
+	existing = false
+
+	supervisor := supervisorServer()
+	defer supervisor.Close()
+
+	lockFile := filepath.Join(os.TempDir(), "gobalena-redaction", "updates.lock")
+
+	// An unreachable supervisor makes the error carry the request URL.
+	unreachable := gobalena.NewLocalClient(apiKey, "http://127.0.0.1:1", supervisorKey, "1",
+		append(opts, gobalena.WithLockFile(lockFile))...)
+	_, err = unreachable.DeviceState(ctx)
+	if err == nil {
+		log.Fatal("expected DeviceState to fail against an unreachable supervisor")
+	}
+	check("network error", err.Error())
+
+	localClient := gobalena.NewLocalClient(apiKey, supervisor.URL, supervisorKey, "1",
+		append(opts, gobalena.WithLockFile(lockFile))...)
+
+	err = localClient.RestartService(ctx, "main")
+	if err == nil {
+		log.Fatal("expected RestartService to fail against the stub")
+	}
+	check("RestartService error", err.Error())
+
+	_, err = localClient.DeviceState(ctx)
+	if err == nil {
+		log.Fatal("expected DeviceState to fail against the stub")
+	}
+	check("DeviceState error", err.Error())
+
+	if !strings.Contains(logs.String(), "curl") {
+		log.Fatal("expected curl commands in the debug output")
+	}
+	check("logs", logs.String())
+
+	if failures > 0 {
+		log.Fatalf("%d leaks found", failures)
+	}
+
+	log.Print("no secrets leaked")
+}
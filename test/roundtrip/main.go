@@ -0,0 +1,192 @@
+// Sends randomly generated service names and env var values through the
+// clients and checks that a local stand-in server decodes exactly what was
+// sent. Quotes, backslashes, control characters and non-ASCII text must all
+// survive the trip, and values that are not valid UTF-8 must be rejected
+// before anything is sent:
+//
+// ```bash
+// go run -tags roundtrip ./test/roundtrip -n 1000 -seed 42
+// ```
+
+//go:build roundtrip
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Round2POS/gobalena/v2"
+)
+
+// alphabet is weighted towards the characters that break hand-built JSON.
+var alphabet = []rune(`"\'{}[]:,/` + "\n\r\t\b\f\x00\x1f  " + "abcXYZ 019_-" + "äß€😀")
+
+// invalidUTF8 holds byte sequences encoding/json would silently replace with
+// U+FFFD: stray continuation bytes, truncated sequences and a surrogate.
+var invalidUTF8 = []string{"\x80", "\xff", "\xc3", "\xe2\x82", "\xed\xa0\x80"}
+
+func randomString(rng *rand.Rand) string {
+	var b strings.Builder
+	for n := rng.Intn(24); n > 0; n-- {
+		b.WriteRune(alphabet[rng.Intn(len(alphabet))])
+	}
+
+	return b.String()
+}
+
+// randomValue is a randomString that is not valid UTF-8 one time in four.
+func randomValue(rng *rand.Rand) string {
+	value := randomString(rng)
+	if rng.Intn(4) > 0 {
+		return value
+	}
+
+	i := rng.Intn(len(value) + 1)
+	return value[:i] + invalidUTF8[rng.Intn(len(invalidUTF8))] + value[i:]
+}
+
+// recorder keeps the last body the stand-in server received.
+type recorder struct {
+	mu   sync.Mutex
+	path string
+	body map[string]any
+	err  error
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.path = req.URL.Path
+	r.body = nil
+	r.err = json.NewDecoder(req.Body).Decode(&r.body)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{}`)
+}
+
+func (r *recorder) last() (string, map[string]any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.path, r.body, r.err
+}
+
+var failures int
+
+func expect(label string, rec *recorder, field string, want any) {
+	path, body, err := rec.last()
+	if err != nil {
+		failures++
+		log.Printf("FAIL %s: %s sent invalid JSON: %v", label, path, err)
+		return
+	}
+
+	if got := body[field]; got != want {
+		failures++
+		log.Printf("FAIL %s: %s sent %s=%#v, want %#v", label, path, field, got, want)
+	}
+}
+
+// expectRejected checks that an invalid value failed up front, before any
+// request was sent.
+func expectRejected(label, value string, err error) {
+	if !errors.Is(err, gobalena.ErrInvalidValue) {
+		failures++
+		log.Printf("FAIL %s(%q): got %v, want ErrInvalidValue", label, value, err)
+	}
+}
+
+func main() {
+	n := flag.Int("n", 500, "number of random inputs")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed")
+	flag.Parse()
+
+	log.Printf("seed %d", *seed)
+	rng := rand.New(rand.NewSource(*seed))
+	ctx := context.Background()
+
+	rejected := 0
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	lockFile := filepath.Join(os.TempDir(), "gobalena-roundtrip", "updates.lock")
+	localClient := gobalena.NewLocalClient("", server.URL, "supervisor-key", "1", gobalena.WithLockFile(lockFile))
+	cloudClient := gobalena.NewCloudClient("api-key", server.URL)
+
+	services := map[string]func(context.Context, string) error{
+		"RestartService": localClient.RestartService,
+		"StopService":    localClient.StopService,
+		"StartService":   localClient.StartService,
+	}
+	forced := map[string]func(context.Context, bool) error{
+		// Lifting the lock here makes UpdateRelease return right after its
+		// request instead of polling the device state until it is applied.
+		"UpdateRelease": func(ctx context.Context, force bool) error {
+			return localClient.UpdateLock().WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+				return localClient.UpdateRelease(ctx, force)
+			})
+		},
+		"RebootSystem": localClient.RebootSystem,
+	}
+
+	for i := 0; i < *n; i++ {
+		for name, call := range services {
+			serviceName := randomString(rng)
+			if err := call(ctx, serviceName); err != nil {
+				log.Fatalf("%s(%q): %v", name, serviceName, err)
+			}
+			expect(name, rec, "serviceName", serviceName)
+		}
+
+		for name, call := range forced {
+			force := rng.Intn(2) == 1
+			if err := call(ctx, force); err != nil {
+				log.Fatalf("%s(%t): %v", name, force, err)
+			}
+			expect(name, rec, "force", force)
+		}
+
+		value := randomValue(rng)
+		if !utf8.ValidString(value) {
+			expectRejected("UpdateDeviceEnvVar", value, cloudClient.UpdateDeviceEnvVar(ctx, 1, 2, value))
+			expectRejected("UpdateDeviceServiceEnvVar", value, cloudClient.UpdateDeviceServiceEnvVar(ctx, 1, 2, value))
+
+			_, err := cloudClient.SetDeviceEnvVar(ctx, "0123456789abcdef0123456789abcdef", "NAME", value)
+			expectRejected("SetDeviceEnvVar", value, err)
+			rejected++
+			continue
+		}
+
+		if err := cloudClient.UpdateDeviceEnvVar(ctx, 1, 2, value); err != nil {
+			log.Fatalf("UpdateDeviceEnvVar(%q): %v", value, err)
+		}
+		expect("UpdateDeviceEnvVar", rec, "value", value)
+
+		if err := cloudClient.UpdateDeviceServiceEnvVar(ctx, 1, 2, value); err != nil {
+			log.Fatalf("UpdateDeviceServiceEnvVar(%q): %v", value, err)
+		}
+		expect("UpdateDeviceServiceEnvVar", rec, "value", value)
+	}
+
+	if failures > 0 {
+		log.Fatalf("%d bodies did not round-trip", failures)
+	}
+
+	log.Printf("%d inputs round-tripped, %d values rejected as invalid UTF-8", *n, rejected)
+}
@@ -15,6 +15,28 @@ func IsValidBalenaDeviceUUID(u string) bool {
 	return !strings.Contains(u, "-")
 }
 
+// minShortUUIDLength keeps ResolveDevice from treating short device names
+// such as "cafe" as UUID prefixes.
+const minShortUUIDLength = 7
+
+// maxAmbiguousMatches bounds how many devices an AmbiguousDeviceError lists.
+const maxAmbiguousMatches = 10
+
+// isShortUUID reports whether s could be a prefix of a balena device UUID.
+func isShortUUID(s string) bool {
+	if len(s) < minShortUUIDLength || len(s) >= 32 {
+		return false
+	}
+
+	for _, r := range strings.ToLower(s) {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func RandomBalenaUUID() string {
 	return FormatBalenaUUID(uuid.New().String())
 }
@@ -0,0 +1,394 @@
+package gobalena
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedCloudClient wraps every CloudClient method in a span. The requests
+// the method sends show up as child spans, see SturdyClient.SetTelemetry.
+type tracedCloudClient struct {
+	next   CloudClient
+	tracer trace.Tracer
+}
+
+func newTracedCloudClient(next CloudClient, tp trace.TracerProvider) CloudClient {
+	return &tracedCloudClient{
+		next:   next,
+		tracer: tp.Tracer(instrumentationName),
+	}
+}
+
+func (t *tracedCloudClient) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, t.tracer, "CloudClient."+method, attrs...)
+}
+
+func (t *tracedCloudClient) GetDevice(ctx context.Context, balenaDeviceUUID string) (*Device, error) {
+	ctx, span := t.start(ctx, "GetDevice", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDevice(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetDeviceDetails(ctx context.Context, balenaDeviceUUID string) (*Device, error) {
+	ctx, span := t.start(ctx, "GetDeviceDetails", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceDetails(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetDevicesDetails(ctx context.Context, balenaDeviceUUIDs []string) ([]Device, error) {
+	ctx, span := t.start(ctx, "GetDevicesDetails")
+	result, err := t.next.GetDevicesDetails(ctx, balenaDeviceUUIDs)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) IterDevicesDetails(ctx context.Context, balenaDeviceUUIDs []string) iter.Seq2[Device, error] {
+	return func(yield func(Device, error) bool) {
+		ctx, span := t.start(ctx, "IterDevicesDetails")
+		var err error
+		defer func() { endSpan(span, err) }()
+
+		for v, e := range t.next.IterDevicesDetails(ctx, balenaDeviceUUIDs) {
+			err = e
+			if !yield(v, e) {
+				return
+			}
+		}
+	}
+}
+
+func (t *tracedCloudClient) ListFleetDevices(ctx context.Context, fleetName string, opts ListDevicesOptions) ([]Device, error) {
+	ctx, span := t.start(ctx, "ListFleetDevices", attrFleet.String(fleetName))
+	result, err := t.next.ListFleetDevices(ctx, fleetName, opts)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) FindDevicesByNetwork(ctx context.Context, fleetName, query string) ([]Device, error) {
+	ctx, span := t.start(ctx, "FindDevicesByNetwork", attrFleet.String(fleetName))
+	result, err := t.next.FindDevicesByNetwork(ctx, fleetName, query)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetDeviceID(ctx context.Context, balenaDeviceUUID string) (int, error) {
+	ctx, span := t.start(ctx, "GetDeviceID", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceID(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) ResolveDevice(ctx context.Context, ref string) (string, error) {
+	ctx, span := t.start(ctx, "ResolveDevice", attrDeviceRef.String(ref))
+	result, err := t.next.ResolveDevice(ctx, ref)
+	if err == nil {
+		span.SetAttributes(attrDeviceUUID.String(result))
+	}
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetFleet(ctx context.Context, name string) (*Fleet, error) {
+	ctx, span := t.start(ctx, "GetFleet", attrFleet.String(name))
+	result, err := t.next.GetFleet(ctx, name)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) RegisterDevice(ctx context.Context, balenaDeviceUUID, fleetName string, deviceType DeviceType) error {
+	ctx, span := t.start(ctx, "RegisterDevice", attrDeviceUUID.String(balenaDeviceUUID), attrFleet.String(fleetName))
+	return endSpan(span, t.next.RegisterDevice(ctx, balenaDeviceUUID, fleetName, deviceType))
+}
+
+func (t *tracedCloudClient) DeleteDevice(ctx context.Context, balenaDeviceUUID string) error {
+	ctx, span := t.start(ctx, "DeleteDevice", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.DeleteDevice(ctx, balenaDeviceUUID))
+}
+
+func (t *tracedCloudClient) Purge(ctx context.Context, balenaDeviceUUID string, force bool) error {
+	ctx, span := t.start(ctx, "Purge", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.Purge(ctx, balenaDeviceUUID, force))
+}
+
+func (t *tracedCloudClient) CreateDeviceEnvVar(ctx context.Context, balenaDeviceUUID, key string, value string) error {
+	ctx, span := t.start(ctx, "CreateDeviceEnvVar", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.CreateDeviceEnvVar(ctx, balenaDeviceUUID, key, value))
+}
+
+func (t *tracedCloudClient) GetDeviceEnvVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceEnvVar, error) {
+	ctx, span := t.start(ctx, "GetDeviceEnvVars", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceEnvVars(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) IterDeviceEnvVars(ctx context.Context, balenaDeviceUUID string) iter.Seq2[DeviceEnvVar, error] {
+	return func(yield func(DeviceEnvVar, error) bool) {
+		ctx, span := t.start(ctx, "IterDeviceEnvVars", attrDeviceUUID.String(balenaDeviceUUID))
+		var err error
+		defer func() { endSpan(span, err) }()
+
+		for v, e := range t.next.IterDeviceEnvVars(ctx, balenaDeviceUUID) {
+			err = e
+			if !yield(v, e) {
+				return
+			}
+		}
+	}
+}
+
+func (t *tracedCloudClient) GetDeviceEnvVarID(ctx context.Context, balenaDeviceID int, key string) (int, error) {
+	ctx, span := t.start(ctx, "GetDeviceEnvVarID", attrDeviceID.Int(balenaDeviceID))
+	result, err := t.next.GetDeviceEnvVarID(ctx, balenaDeviceID, key)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) UpdateDeviceEnvVar(ctx context.Context, balenaDeviceID, envVarID int, value string) error {
+	ctx, span := t.start(ctx, "UpdateDeviceEnvVar", attrDeviceID.Int(balenaDeviceID))
+	return endSpan(span, t.next.UpdateDeviceEnvVar(ctx, balenaDeviceID, envVarID, value))
+}
+
+func (t *tracedCloudClient) DeleteDeviceEnvVar(ctx context.Context, balenaDeviceID, envVarID int) error {
+	ctx, span := t.start(ctx, "DeleteDeviceEnvVar", attrDeviceID.Int(balenaDeviceID))
+	return endSpan(span, t.next.DeleteDeviceEnvVar(ctx, balenaDeviceID, envVarID))
+}
+
+func (t *tracedCloudClient) SetDeviceEnvVar(ctx context.Context, balenaDeviceUUID, name, value string) (bool, error) {
+	ctx, span := t.start(ctx, "SetDeviceEnvVar", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.SetDeviceEnvVar(ctx, balenaDeviceUUID, name, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetFleetEnvVars(ctx context.Context, name string) ([]FleetEnvVar, error) {
+	ctx, span := t.start(ctx, "GetFleetEnvVars", attrFleet.String(name))
+	result, err := t.next.GetFleetEnvVars(ctx, name)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) IterFleetEnvVars(ctx context.Context, name string) iter.Seq2[FleetEnvVar, error] {
+	return func(yield func(FleetEnvVar, error) bool) {
+		ctx, span := t.start(ctx, "IterFleetEnvVars", attrFleet.String(name))
+		var err error
+		defer func() { endSpan(span, err) }()
+
+		for v, e := range t.next.IterFleetEnvVars(ctx, name) {
+			err = e
+			if !yield(v, e) {
+				return
+			}
+		}
+	}
+}
+
+func (t *tracedCloudClient) CreateFleetEnvVar(ctx context.Context, fleetName, name, value string) error {
+	ctx, span := t.start(ctx, "CreateFleetEnvVar", attrFleet.String(fleetName))
+	return endSpan(span, t.next.CreateFleetEnvVar(ctx, fleetName, name, value))
+}
+
+func (t *tracedCloudClient) UpdateFleetEnvVar(ctx context.Context, fleetName, name, value string) error {
+	ctx, span := t.start(ctx, "UpdateFleetEnvVar", attrFleet.String(fleetName))
+	return endSpan(span, t.next.UpdateFleetEnvVar(ctx, fleetName, name, value))
+}
+
+func (t *tracedCloudClient) UpsertFleetEnvVar(ctx context.Context, fleetName, name, value string) (bool, error) {
+	ctx, span := t.start(ctx, "UpsertFleetEnvVar", attrFleet.String(fleetName))
+	result, err := t.next.UpsertFleetEnvVar(ctx, fleetName, name, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) DeleteFleetEnvVar(ctx context.Context, fleetName, name string) error {
+	ctx, span := t.start(ctx, "DeleteFleetEnvVar", attrFleet.String(fleetName))
+	return endSpan(span, t.next.DeleteFleetEnvVar(ctx, fleetName, name))
+}
+
+func (t *tracedCloudClient) GetServiceEnvVars(ctx context.Context, fleetName string) ([]ServiceEnvVar, error) {
+	ctx, span := t.start(ctx, "GetServiceEnvVars", attrFleet.String(fleetName))
+	result, err := t.next.GetServiceEnvVars(ctx, fleetName)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) CreateServiceEnvVar(ctx context.Context, fleetName, serviceName, name, value string) error {
+	ctx, span := t.start(ctx, "CreateServiceEnvVar", attrFleet.String(fleetName), attrService.String(serviceName))
+	return endSpan(span, t.next.CreateServiceEnvVar(ctx, fleetName, serviceName, name, value))
+}
+
+func (t *tracedCloudClient) UpdateServiceEnvVar(ctx context.Context, fleetName, serviceName, name, value string) error {
+	ctx, span := t.start(ctx, "UpdateServiceEnvVar", attrFleet.String(fleetName), attrService.String(serviceName))
+	return endSpan(span, t.next.UpdateServiceEnvVar(ctx, fleetName, serviceName, name, value))
+}
+
+func (t *tracedCloudClient) UpsertServiceEnvVar(ctx context.Context, fleetName, serviceName, name, value string) (bool, error) {
+	ctx, span := t.start(ctx, "UpsertServiceEnvVar", attrFleet.String(fleetName), attrService.String(serviceName))
+	result, err := t.next.UpsertServiceEnvVar(ctx, fleetName, serviceName, name, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) DeleteServiceEnvVar(ctx context.Context, fleetName, serviceName, name string) error {
+	ctx, span := t.start(ctx, "DeleteServiceEnvVar", attrFleet.String(fleetName), attrService.String(serviceName))
+	return endSpan(span, t.next.DeleteServiceEnvVar(ctx, fleetName, serviceName, name))
+}
+
+func (t *tracedCloudClient) GetDeviceServiceInstallIDs(ctx context.Context, balenaDeviceUUID string) ([]DeviceServiceInstall, error) {
+	ctx, span := t.start(ctx, "GetDeviceServiceInstallIDs", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceServiceInstallIDs(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetEffectiveEnvVars(ctx context.Context, balenaDeviceUUID string) (map[string][]GenericEnvVar, error) {
+	ctx, span := t.start(ctx, "GetEffectiveEnvVars", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetEffectiveEnvVars(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetDeviceConfigVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceConfigVar, error) {
+	ctx, span := t.start(ctx, "GetDeviceConfigVars", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceConfigVars(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetDeviceConfigVar(ctx context.Context, balenaDeviceUUID, name string) (*DeviceConfigVar, error) {
+	ctx, span := t.start(ctx, "GetDeviceConfigVar", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceConfigVar(ctx, balenaDeviceUUID, name)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) SetDeviceConfigVar(ctx context.Context, balenaDeviceUUID, name, value string) (bool, error) {
+	ctx, span := t.start(ctx, "SetDeviceConfigVar", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.SetDeviceConfigVar(ctx, balenaDeviceUUID, name, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) DeleteDeviceConfigVar(ctx context.Context, balenaDeviceUUID, name string) error {
+	ctx, span := t.start(ctx, "DeleteDeviceConfigVar", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.DeleteDeviceConfigVar(ctx, balenaDeviceUUID, name))
+}
+
+func (t *tracedCloudClient) GetFleetConfigVars(ctx context.Context, fleetName string) ([]FleetConfigVar, error) {
+	ctx, span := t.start(ctx, "GetFleetConfigVars", attrFleet.String(fleetName))
+	result, err := t.next.GetFleetConfigVars(ctx, fleetName)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) GetFleetConfigVar(ctx context.Context, fleetName, name string) (*FleetConfigVar, error) {
+	ctx, span := t.start(ctx, "GetFleetConfigVar", attrFleet.String(fleetName))
+	result, err := t.next.GetFleetConfigVar(ctx, fleetName, name)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) SetFleetConfigVar(ctx context.Context, fleetName, name, value string) (bool, error) {
+	ctx, span := t.start(ctx, "SetFleetConfigVar", attrFleet.String(fleetName))
+	result, err := t.next.SetFleetConfigVar(ctx, fleetName, name, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) DeleteFleetConfigVar(ctx context.Context, fleetName, name string) error {
+	ctx, span := t.start(ctx, "DeleteFleetConfigVar", attrFleet.String(fleetName))
+	return endSpan(span, t.next.DeleteFleetConfigVar(ctx, fleetName, name))
+}
+
+func (t *tracedCloudClient) CreateDeviceServiceEnvVar(ctx context.Context, balenaDeviceUUID, name string, serviceInstallID int, value string) error {
+	ctx, span := t.start(ctx, "CreateDeviceServiceEnvVar", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.CreateDeviceServiceEnvVar(ctx, balenaDeviceUUID, name, serviceInstallID, value))
+}
+
+func (t *tracedCloudClient) GetDeviceServiceEnvVars(ctx context.Context, balenaDeviceUUID string) ([]DeviceServiceEnvVar, error) {
+	ctx, span := t.start(ctx, "GetDeviceServiceEnvVars", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceServiceEnvVars(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) UpdateDeviceServiceEnvVar(ctx context.Context, balenaDeviceID, envVarID int, value string) error {
+	ctx, span := t.start(ctx, "UpdateDeviceServiceEnvVar", attrDeviceID.Int(balenaDeviceID))
+	return endSpan(span, t.next.UpdateDeviceServiceEnvVar(ctx, balenaDeviceID, envVarID, value))
+}
+
+func (t *tracedCloudClient) SetDeviceServiceEnvVar(ctx context.Context, balenaDeviceUUID, serviceName, name, value string) (bool, error) {
+	ctx, span := t.start(ctx, "SetDeviceServiceEnvVar", attrDeviceUUID.String(balenaDeviceUUID), attrService.String(serviceName))
+	result, err := t.next.SetDeviceServiceEnvVar(ctx, balenaDeviceUUID, serviceName, name, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) ForceApply(ctx context.Context, balenaDeviceUUID string) error {
+	ctx, span := t.start(ctx, "ForceApply", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.ForceApply(ctx, balenaDeviceUUID))
+}
+
+func (t *tracedCloudClient) RestartAllServices(ctx context.Context, balenaDeviceUUID string, force bool) error {
+	ctx, span := t.start(ctx, "RestartAllServices", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.RestartAllServices(ctx, balenaDeviceUUID, force))
+}
+
+func (t *tracedCloudClient) DeleteDeviceServiceEnvVar(ctx context.Context, balenaDeviceID, envVarID int) error {
+	ctx, span := t.start(ctx, "DeleteDeviceServiceEnvVar", attrDeviceID.Int(balenaDeviceID))
+	return endSpan(span, t.next.DeleteDeviceServiceEnvVar(ctx, balenaDeviceID, envVarID))
+}
+
+func (t *tracedCloudClient) SetDeviceName(ctx context.Context, balenaDeviceUUID, name string) error {
+	ctx, span := t.start(ctx, "SetDeviceName", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.SetDeviceName(ctx, balenaDeviceUUID, name))
+}
+
+func (t *tracedCloudClient) GetDeviceTags(ctx context.Context, balenaDeviceUUID string) ([]DeviceTag, error) {
+	ctx, span := t.start(ctx, "GetDeviceTags", attrDeviceUUID.String(balenaDeviceUUID))
+	result, err := t.next.GetDeviceTags(ctx, balenaDeviceUUID)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) SetDeviceTag(ctx context.Context, balenaDeviceUUID, key, value string) error {
+	ctx, span := t.start(ctx, "SetDeviceTag", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.SetDeviceTag(ctx, balenaDeviceUUID, key, value))
+}
+
+func (t *tracedCloudClient) DeleteDeviceTag(ctx context.Context, balenaDeviceUUID, key string) error {
+	ctx, span := t.start(ctx, "DeleteDeviceTag", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.DeleteDeviceTag(ctx, balenaDeviceUUID, key))
+}
+
+func (t *tracedCloudClient) ListDevicesByTag(ctx context.Context, fleetName, key, value string) ([]Device, error) {
+	ctx, span := t.start(ctx, "ListDevicesByTag", attrFleet.String(fleetName))
+	result, err := t.next.ListDevicesByTag(ctx, fleetName, key, value)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) DownloadOS(ctx context.Context, writer io.Writer, fleet string, deviceType DeviceType, version string, headerSetter HeaderSetter) (string, error) {
+	ctx, span := t.start(ctx, "DownloadOS", attrFleet.String(fleet))
+	result, err := t.next.DownloadOS(ctx, writer, fleet, deviceType, version, headerSetter)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) MoveDeviceToFleet(ctx context.Context, balenaDeviceUUID, fleetName string) error {
+	ctx, span := t.start(ctx, "MoveDeviceToFleet", attrDeviceUUID.String(balenaDeviceUUID), attrFleet.String(fleetName))
+	return endSpan(span, t.next.MoveDeviceToFleet(ctx, balenaDeviceUUID, fleetName))
+}
+
+func (t *tracedCloudClient) EnablePublicDeviceURL(ctx context.Context, balenaDeviceUUID string) error {
+	ctx, span := t.start(ctx, "EnablePublicDeviceURL", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.EnablePublicDeviceURL(ctx, balenaDeviceUUID))
+}
+
+func (t *tracedCloudClient) HostLogin(token string) error {
+	return t.next.HostLogin(token)
+}
+
+func (t *tracedCloudClient) GetFleetReleases(ctx context.Context, name string) ([]Release, error) {
+	ctx, span := t.start(ctx, "GetFleetReleases", attrFleet.String(name))
+	result, err := t.next.GetFleetReleases(ctx, name)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedCloudClient) IterFleetReleases(ctx context.Context, name string) iter.Seq2[Release, error] {
+	return func(yield func(Release, error) bool) {
+		ctx, span := t.start(ctx, "IterFleetReleases", attrFleet.String(name))
+		var err error
+		defer func() { endSpan(span, err) }()
+
+		for v, e := range t.next.IterFleetReleases(ctx, name) {
+			err = e
+			if !yield(v, e) {
+				return
+			}
+		}
+	}
+}
+
+func (t *tracedCloudClient) PinDeviceToRelease(ctx context.Context, balenaDeviceUUID string, releaseID int) error {
+	ctx, span := t.start(ctx, "PinDeviceToRelease", attrDeviceUUID.String(balenaDeviceUUID))
+	return endSpan(span, t.next.PinDeviceToRelease(ctx, balenaDeviceUUID, releaseID))
+}
@@ -0,0 +1,88 @@
+package gobalena
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedLocalClient wraps every LocalClient method in a span, like
+// tracedCloudClient.
+type tracedLocalClient struct {
+	next   LocalClient
+	tracer trace.Tracer
+}
+
+func newTracedLocalClient(next LocalClient, tp trace.TracerProvider) LocalClient {
+	return &tracedLocalClient{
+		next:   next,
+		tracer: tp.Tracer(instrumentationName),
+	}
+}
+
+func (t *tracedLocalClient) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, t.tracer, "LocalClient."+method, attrs...)
+}
+
+func (t *tracedLocalClient) RestartService(ctx context.Context, serviceName string) error {
+	ctx, span := t.start(ctx, "RestartService", attrService.String(serviceName))
+	return endSpan(span, t.next.RestartService(ctx, serviceName))
+}
+
+func (t *tracedLocalClient) StopService(ctx context.Context, serviceName string) error {
+	ctx, span := t.start(ctx, "StopService", attrService.String(serviceName))
+	return endSpan(span, t.next.StopService(ctx, serviceName))
+}
+
+func (t *tracedLocalClient) StartService(ctx context.Context, serviceName string) error {
+	ctx, span := t.start(ctx, "StartService", attrService.String(serviceName))
+	return endSpan(span, t.next.StartService(ctx, serviceName))
+}
+
+func (t *tracedLocalClient) ServicesStatus(ctx context.Context) (*Status, error) {
+	ctx, span := t.start(ctx, "ServicesStatus")
+	result, err := t.next.ServicesStatus(ctx)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedLocalClient) UpdateRelease(ctx context.Context, force bool) error {
+	ctx, span := t.start(ctx, "UpdateRelease")
+	return endSpan(span, t.next.UpdateRelease(ctx, force))
+}
+
+func (t *tracedLocalClient) RebootSystem(ctx context.Context, force bool) error {
+	ctx, span := t.start(ctx, "RebootSystem")
+	return endSpan(span, t.next.RebootSystem(ctx, force))
+}
+
+func (t *tracedLocalClient) ShutdownSystem(ctx context.Context) error {
+	ctx, span := t.start(ctx, "ShutdownSystem")
+	return endSpan(span, t.next.ShutdownSystem(ctx))
+}
+
+func (t *tracedLocalClient) ServicesState(ctx context.Context) (*map[string]interface{}, error) {
+	ctx, span := t.start(ctx, "ServicesState")
+	result, err := t.next.ServicesState(ctx)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedLocalClient) DeviceState(ctx context.Context) (*DeviceState, error) {
+	ctx, span := t.start(ctx, "DeviceState")
+	result, err := t.next.DeviceState(ctx)
+	return result, endSpan(span, err)
+}
+
+func (t *tracedLocalClient) Purge(ctx context.Context) error {
+	ctx, span := t.start(ctx, "Purge")
+	return endSpan(span, t.next.Purge(ctx))
+}
+
+func (t *tracedLocalClient) StreamLogs(ctx context.Context, stream chan []byte) error {
+	ctx, span := t.start(ctx, "StreamLogs")
+	return endSpan(span, t.next.StreamLogs(ctx, stream))
+}
+
+func (t *tracedLocalClient) UpdateLock() *UpdateLock {
+	return t.next.UpdateLock()
+}
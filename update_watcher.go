@@ -0,0 +1,248 @@
+package gobalena
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	watcherLockHolder            = "update-watcher"
+	defaultWatcherPollInterval   = 30 * time.Second
+	defaultApplyTimeout          = 15 * time.Minute
+	defaultWatcherApplyPollDelay = 5 * time.Second
+	defaultWatcherRejectInterval = time.Hour
+)
+
+type updateVerdict int
+
+const (
+	verdictApprove updateVerdict = iota
+	verdictDefer
+	verdictReject
+)
+
+// UpdateDecision is the application's answer to a pending update, see
+// ApproveUpdate, DeferUpdate and RejectUpdate.
+type UpdateDecision struct {
+	verdict updateVerdict
+	delay   time.Duration
+}
+
+var (
+	// ApproveUpdate lets the update through as soon as no critical section
+	// is open on the update lock.
+	ApproveUpdate = UpdateDecision{verdict: verdictApprove}
+	// RejectUpdate keeps the update out. As the watcher holds the lock, the
+	// supervisor keeps reporting the update, so the handler is asked again
+	// once RejectInterval has passed, or sooner if the device's commit
+	// changes.
+	RejectUpdate = UpdateDecision{verdict: verdictReject}
+)
+
+// DeferUpdate asks again about the same update once d has passed.
+func DeferUpdate(d time.Duration) UpdateDecision {
+	return UpdateDecision{verdict: verdictDefer, delay: d}
+}
+
+// UpdateHandler decides what to do about the update described by state. It is
+// called whenever an update becomes pending or downloaded, again after a
+// deferral or rejection ends, and after an approved update failed to apply.
+type UpdateHandler func(ctx context.Context, state *DeviceState) UpdateDecision
+
+type UpdateWatcherConfig struct {
+	// PollInterval is how often DeviceState is polled. Defaults to 30
+	// seconds.
+	PollInterval time.Duration
+	// ApplyTimeout bounds how long the lock stays lifted after an approval
+	// while waiting for the supervisor to apply the update. Defaults to 15
+	// minutes.
+	ApplyTimeout time.Duration
+	// RejectInterval is how long a RejectUpdate stands before the handler
+	// is asked again. Defaults to one hour.
+	RejectInterval time.Duration
+	ForceUpdate    bool
+	// OnError, if set, is called with every error that Run retries on the
+	// next tick, such as an approved update failing to apply.
+	OnError func(err error)
+}
+
+// UpdateWatcher holds the client's update lock while it runs and only lifts
+// it for updates its handler approves.
+type UpdateWatcher struct {
+	client  LocalClient
+	handler UpdateHandler
+	config  UpdateWatcherConfig
+
+	// Keyed on the pending/downloaded flags, so that the handler is only
+	// asked again when the update progresses.
+	asked       deviceUpdateState
+	deferUntil  time.Time
+	rejectUntil time.Time
+	// rejectedCommit is the commit the device ran when the update was
+	// rejected.
+	rejectedCommit string
+}
+
+type deviceUpdateState struct {
+	pending    bool
+	downloaded bool
+}
+
+func NewUpdateWatcher(client LocalClient, handler UpdateHandler, config UpdateWatcherConfig) *UpdateWatcher {
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultWatcherPollInterval
+	}
+
+	if config.ApplyTimeout <= 0 {
+		config.ApplyTimeout = defaultApplyTimeout
+	}
+
+	if config.RejectInterval <= 0 {
+		config.RejectInterval = defaultWatcherRejectInterval
+	}
+
+	return &UpdateWatcher{
+		client:  client,
+		handler: handler,
+		config:  config,
+	}
+}
+
+// Run takes the update lock and watches the device state until ctx is done.
+// Errors, including an approved update failing to apply, are passed to
+// OnError and retried on the next tick, so the lock is held throughout.
+func (w *UpdateWatcher) Run(ctx context.Context) (err error) {
+	release, err := w.client.UpdateLock().Acquire(ctx, watcherLockHolder)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, release())
+	}()
+
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(ctx); err != nil && ctx.Err() == nil && w.config.OnError != nil {
+			w.config.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *UpdateWatcher) poll(ctx context.Context) error {
+	state, err := w.client.DeviceState(ctx)
+	if err != nil {
+		// The supervisor is briefly unreachable while it restarts, so the
+		// next tick may well succeed.
+		return fmt.Errorf("error checking for pending update: %w", err)
+	}
+
+	current := deviceUpdateState{pending: state.UpdatePending, downloaded: state.UpdateDownloaded}
+	if current == (deviceUpdateState{}) {
+		w.asked = current
+		w.deferUntil = time.Time{}
+		w.rejectUntil = time.Time{}
+		return nil
+	}
+
+	if !w.rejectUntil.IsZero() {
+		if time.Now().Before(w.rejectUntil) && state.Commit == w.rejectedCommit {
+			return nil
+		}
+
+		w.rejectUntil = time.Time{}
+		w.asked = deviceUpdateState{}
+	}
+
+	if !w.deferUntil.IsZero() {
+		if time.Now().Before(w.deferUntil) {
+			return nil
+		}
+	} else if current == w.asked {
+		return nil
+	}
+
+	w.asked = current
+	w.deferUntil = time.Time{}
+
+	decision := w.handler(ctx, state)
+	switch decision.verdict {
+	case verdictDefer:
+		w.deferUntil = time.Now().Add(decision.delay)
+	case verdictReject:
+		w.rejectUntil = time.Now().Add(w.config.RejectInterval)
+		w.rejectedCommit = state.Commit
+	case verdictApprove:
+		if err := w.apply(ctx); err != nil {
+			// Ask again, and thus retry, on the next tick.
+			w.asked = deviceUpdateState{}
+			return err
+		}
+
+		// Ask again if the update is still around after the lock went back.
+		w.asked = deviceUpdateState{}
+	}
+
+	return nil
+}
+
+func (w *UpdateWatcher) apply(ctx context.Context) error {
+	if err := applyUpdate(ctx, w.client, w.config.ForceUpdate, w.config.ApplyTimeout); err != nil {
+		return fmt.Errorf("error applying approved update: %w", err)
+	}
+
+	return nil
+}
+
+// applyUpdate lifts the lock, asks the supervisor to update and keeps the
+// lock lifted until the update is no longer reported or timeout has passed.
+// The supervisor applies updates in the background, so restoring the lock
+// right after the request returned would have it refuse to stop services.
+func applyUpdate(ctx context.Context, client LocalClient, force bool, timeout time.Duration) error {
+	return client.UpdateLock().WithUpdatesAllowed(ctx, func(ctx context.Context) error {
+		if err := client.UpdateRelease(ctx, force); err != nil {
+			return err
+		}
+
+		waitForUpdate(ctx, client, timeout)
+
+		return nil
+	})
+}
+
+// waitForUpdate returns once the supervisor no longer reports an update, or
+// after timeout. An update still in flight by then is left to the caller to
+// try again; the lock goes back either way.
+func waitForUpdate(ctx context.Context, client LocalClient, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(defaultWatcherApplyPollDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, err := client.DeviceState(ctx)
+		if err != nil {
+			continue
+		}
+
+		if !state.UpdatePending && !state.UpdateDownloaded {
+			return
+		}
+	}
+}